@@ -9,3 +9,16 @@ for file in $(ls -v deployments/); do
     envsubst < deployments$DEPLOYMENT_FLAVOUR/${file} | ${KUBE_EXEC} apply -f -
 done;
 
+# the features requiring host privileges are enabled by patching the daemonset
+DAEMONSET_NAMESPACE=$NAMESPACE
+if [ "$DEPLOYMENT_FLAVOUR" = "-k8s" ]; then
+    DAEMONSET_NAMESPACE=network-metrics
+fi
+if [ "$INTERFACE_STATS" = "true" ]; then
+    echo "INFO - Applying patch deployments-patches/interface-stats.yaml"
+    ${KUBE_EXEC} -n $DAEMONSET_NAMESPACE patch daemonset network-metrics-daemon --type=json --patch "$(cat deployments-patches/interface-stats.yaml)"
+fi
+if [ "$HOST_DEVICES" = "true" ]; then
+    echo "INFO - Applying patch deployments-patches/host-devices.yaml"
+    ${KUBE_EXEC} -n $DAEMONSET_NAMESPACE patch daemonset network-metrics-daemon --type=json --patch "$(cat deployments-patches/host-devices.yaml)"
+fi
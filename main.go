@@ -4,8 +4,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -15,8 +22,18 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
 
+	daemonconfig "github.com/openshift/network-metrics-daemon/pkg/config"
 	"github.com/openshift/network-metrics-daemon/pkg/controller"
+	"github.com/openshift/network-metrics-daemon/pkg/daemonmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/health"
+	"github.com/openshift/network-metrics-daemon/pkg/hostdevices"
+	"github.com/openshift/network-metrics-daemon/pkg/netattachdef"
+	"github.com/openshift/network-metrics-daemon/pkg/otlp"
 	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podstats"
+	"github.com/openshift/network-metrics-daemon/pkg/remotewrite"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"github.com/openshift/network-metrics-daemon/pkg/serving"
 	"github.com/openshift/network-metrics-daemon/pkg/signals"
 )
 
@@ -30,23 +47,111 @@ func main() {
 		masterURL      string
 		metricsAddress string
 		currentNode    string
+		interfaceStats bool
+		interfaceState bool
+		procRoot       string
+		hostDevices    bool
+		vfStats        bool
+		sysRoot        string
+		configFile     string
+		podLabels      string
+		nadLabels      string
+		daemonMetrics  string
+		watchFailure   time.Duration
+		drainTimeout   time.Duration
+		tls            serving.TLSOptions
+		cipherSuites   string
+		authorize      bool
+		remoteWrite    remotewrite.Options
+		otlp           otlp.Options
+		prometheus     bool
+		resolveNADs    bool
 	}
 
 	flag.StringVar(&config.kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&config.masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&config.metricsAddress, "metrics-listen-address", ":9091", "metrics server listen address.")
 	flag.StringVar(&config.currentNode, "node-name", "", "the node the daemon is running on.")
+	flag.BoolVar(&config.interfaceStats, "interface-stats", false, "collect the interface counters from within the pods' network namespace.")
+	flag.BoolVar(&config.interfaceState, "interface-state", false, "collect the state of the interfaces, i.e. up, MTU and speed, from within the pods' network namespace.")
+	flag.StringVar(&config.procRoot, "proc-root", "/proc", "the host proc filesystem, used to find the pods' network namespace.")
+	flag.BoolVar(&config.hostDevices, "host-devices", false, "publish the inventory of the host network devices, such as the SR-IOV physical and virtual functions, the bridges and the bonds. Requires the host network.")
+	flag.BoolVar(&config.vfStats, "vf-stats", false, "collect the counters of the SR-IOV virtual functions added to the pods from their physical function. Requires the host network.")
+	flag.StringVar(&config.sysRoot, "sys-root", "/sys", "the host sys filesystem, used to describe the host network devices.")
+	flag.StringVar(&config.podLabels, "pod-label-allowlist", "", "Comma separated list of pod labels to be added to pod_network_name_info, \"*\" to add all of them.")
+	flag.StringVar(&config.daemonMetrics, "daemon-metrics-path", "", "If set, the path the metrics about the daemon itself are served at, i.e. /daemon-metrics.")
+	flag.DurationVar(&config.watchFailure, "watch-failure-threshold", 5*time.Minute, "How long the pod watch can be failing before /healthz and /readyz report a failure.")
+	flag.DurationVar(&config.drainTimeout, "shutdown-timeout", 20*time.Second, "How long to wait for the workers and the in flight requests to complete when shutting down.")
+	flag.StringVar(&config.tls.CertFile, "tls-cert-file", "", "If set, the metrics are served over TLS with this certificate, reloaded when changed.")
+	flag.StringVar(&config.tls.KeyFile, "tls-private-key-file", "", "The key of the certificate set with --tls-cert-file.")
+	flag.StringVar(&config.tls.ClientCAFile, "tls-client-ca-file", "", "If set, the client certificates signed by this CA are accepted to authenticate the scrapers.")
+	flag.StringVar(&config.tls.MinVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version, VersionTLS12 or VersionTLS13.")
+	flag.StringVar(&config.cipherSuites, "tls-cipher-suites", "", "Comma separated list of the allowed cipher suites IANA names, the insecure ones being rejected. The go defaults are used if not set.")
+	flag.BoolVar(&config.authorize, "authorize-scrapers", false, "Authenticate the scrapers via client certificates or TokenReview, and authorize them via SubjectAccessReview on the requested path. Requires TLS.")
+	config.remoteWrite = remotewrite.DefaultOptions()
+	flag.StringVar(&config.remoteWrite.URL, "remote-write-url", "", "If set, the network metrics are pushed to this Prometheus remote write endpoint.")
+	flag.DurationVar(&config.remoteWrite.Interval, "remote-write-interval", config.remoteWrite.Interval, "How often the network metrics are pushed to the remote write endpoint.")
+	flag.IntVar(&config.remoteWrite.QueueSize, "remote-write-queue-size", config.remoteWrite.QueueSize, "How many snapshots of the metrics are kept while the remote write endpoint is not reachable.")
+	flag.StringVar(&config.remoteWrite.BearerTokenFile, "remote-write-bearer-token-file", "", "If set, the bearer token sent to the remote write endpoint.")
+	config.otlp = otlp.DefaultOptions()
+	flag.StringVar(&config.otlp.Endpoint, "otlp-endpoint", "", "If set, the network metrics are exported to this OpenTelemetry collector url, i.e. http://collector:4317 with grpc or http://collector:4318/v1/metrics with http/protobuf.")
+	flag.StringVar(&config.otlp.Protocol, "otlp-protocol", config.otlp.Protocol, "The protocol used to export the metrics via OTLP, either grpc or http/protobuf.")
+	flag.DurationVar(&config.otlp.Interval, "otlp-interval", config.otlp.Interval, "How often the network metrics are exported via OTLP.")
+	flag.BoolVar(&config.prometheus, "prometheus-metrics", true, "Serve the network metrics on /metrics. Can be disabled when they are exported otherwise.")
+	flag.BoolVar(&config.resolveNADs, "resolve-network-attachment-definitions", false, "Add the plugin type, the resource name and the allowed labels of the network attachment definitions to pod_network_name_info.")
+	flag.StringVar(&config.nadLabels, "nad-label-allowlist", "", "Comma separated list of network attachment definition labels to be added to pod_network_name_info when the definitions are resolved, \"*\" to add all of them.")
+	flag.StringVar(&config.configFile, "config", "", "Path to a YAML or JSON config file. It is watched and applied when changed.")
 
 	flag.Parse()
 
 	if config.currentNode == "" {
 		klog.Fatalf("--node-name required parameter not set")
 	}
+	if (config.tls.CertFile == "") != (config.tls.KeyFile == "") {
+		klog.Fatalf("--tls-cert-file and --tls-private-key-file must be set together")
+	}
+	if config.authorize && config.tls.CertFile == "" {
+		klog.Fatalf("--authorize-scrapers requires --tls-cert-file")
+	}
+	if config.cipherSuites != "" {
+		config.tls.CipherSuites = strings.Split(config.cipherSuites, ",")
+	}
 	klog.Info("Version:", build)
 	klog.Info("Starting with config", config)
 
+	// the flags provide the defaults the config file is applied on top of
+	baseConfig := daemonconfig.Default()
+	baseConfig.Metrics.InterfaceStats = config.interfaceStats
+	baseConfig.Metrics.InterfaceState = config.interfaceState
+	baseConfig.Metrics.HostDevices = config.hostDevices
+	baseConfig.Metrics.VFStats = config.vfStats
+	if config.podLabels != "" {
+		for _, l := range strings.Split(config.podLabels, ",") {
+			baseConfig.Labels.PodLabelAllowlist = append(baseConfig.Labels.PodLabelAllowlist, strings.TrimSpace(l))
+		}
+	}
+	if config.nadLabels != "" {
+		for _, l := range strings.Split(config.nadLabels, ",") {
+			baseConfig.Labels.NADLabelAllowlist = append(baseConfig.Labels.NADLabelAllowlist, strings.TrimSpace(l))
+		}
+	}
+	if err := baseConfig.Validate(); err != nil {
+		klog.Fatalf("Invalid config: %s", err.Error())
+	}
+	daemonConfig := baseConfig
+	if config.configFile != "" {
+		loaded, err := daemonconfig.Load(config.configFile, baseConfig)
+		if err != nil {
+			klog.Fatalf("Error loading config: %s", err.Error())
+		}
+		daemonConfig = *loaded
+	}
+	klog.Infof("Daemon config %+v", daemonConfig)
+
 	// set up signals so we handle the first shutdown signal gracefully
-	stopCh := signals.SetupSignalHandler()
+	ctx, cancel := context.WithCancel(signals.SetupSignalContext())
+	defer cancel()
+	stopCh := ctx.Done()
 
 	cfg, err := clientcmd.BuildConfigFromFlags(config.masterURL, config.kubeconfig)
 	if err != nil {
@@ -71,16 +176,180 @@ func main() {
 			},
 		},
 		&v1.Pod{},
-		time.Second*30,
+		daemonConfig.ResyncPeriod.Duration,
 		cache.Indexers{},
 	)
 
-	ctrl := controller.New(kubeClient, informer, config.currentNode)
+	serverOptions := podmetrics.ServerOptions{
+		Address:         config.metricsAddress,
+		ShutdownTimeout: config.drainTimeout,
+		DisableMetrics:  !config.prometheus,
+	}
+	if config.tls.CertFile != "" {
+		reloader, err := serving.NewCertReloader(config.tls.CertFile, config.tls.KeyFile)
+		if err != nil {
+			klog.Fatalf("Error loading certificate: %s", err.Error())
+		}
+		serverOptions.TLSConfig, err = serving.NewTLSConfig(config.tls, reloader)
+		if err != nil {
+			klog.Fatalf("Error building TLS config: %s", err.Error())
+		}
+		go func() {
+			if err := reloader.Watch(stopCh); err != nil {
+				klog.Errorf("Error watching certificate: %s", err.Error())
+			}
+		}()
+	}
+	if config.authorize {
+		serverOptions.Authorize = serving.NewAuthorizer(kubeClient).Wrap
+	}
+
+	handlers := map[string]http.Handler{}
+	var daemonMetrics *daemonmetrics.Metrics
+	if config.daemonMetrics == "/metrics" || config.daemonMetrics == "/healthz" || config.daemonMetrics == "/readyz" {
+		klog.Fatalf("--daemon-metrics-path must not be %s", config.daemonMetrics)
+	}
+	if config.daemonMetrics != "" {
+		daemonMetrics = daemonmetrics.New(build)
+		handler := daemonMetrics.Handler()
+		if serverOptions.Authorize != nil {
+			handler = serverOptions.Authorize(handler)
+		}
+		handlers[config.daemonMetrics] = handler
+	}
+
+	podMetrics := podmetrics.New()
+	ctrl := controller.New(kubeClient, informer, podMetrics, daemonMetrics, config.currentNode)
+	ctrl.SetWatchFailureThreshold(config.watchFailure)
+	healthz, readyz := health.NewChecker(), health.NewChecker()
+	ctrl.AddHealthChecks(healthz, readyz)
+	handlers["/healthz"] = healthz
+	handlers["/readyz"] = readyz
 	go informer.Run(stopCh)
 
-	podmetrics.Serve(config.metricsAddress, stopCh)
+	if config.resolveNADs {
+		nadClient, err := nadclient.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("Error building network attachment definition clientset: %s", err.Error())
+		}
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, daemonConfig.ResyncPeriod.Duration)
+		podMetrics.SetNetworkResolver(netattachdef.NewResolver(nadInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()))
+		nadInformerFactory.Start(stopCh)
+	}
+
+	stats := podstats.NewCollector(podstats.NewProcResolver(config.procRoot))
+	ctrl.SetStatsCollector(stats)
+	devices := hostdevices.NewCollector(config.sysRoot, rtnl.ListLinks, podMetrics)
+	vfStats := hostdevices.NewVFCollector(config.sysRoot, rtnl.ListLinksWithVFs, podMetrics)
+
+	applyConfig := func(c *daemonconfig.Config) {
+		podMetrics.SetOptions(c.PodMetricsOptions())
+		stats.SetEnabled(c.Metrics.InterfaceStats)
+		stats.SetStateEnabled(c.Metrics.InterfaceState)
+		stats.SetLabelOptions(c.LabelOptions())
+		devices.SetEnabled(c.Metrics.HostDevices)
+		devices.SetLabelOptions(c.LabelOptions())
+		vfStats.SetEnabled(c.Metrics.VFStats)
+		vfStats.SetLabelOptions(c.LabelOptions())
+		ctrl.SetStatusAnnotations(c.NetworkStatusAnnotations()...)
+		ctrl.SetTerminatedGracePeriod(c.TerminatedPodGracePeriod.Duration)
+	}
+	applyConfig(&daemonConfig)
+
+	if config.configFile != "" {
+		go func() {
+			loaded := daemonConfig
+			err := daemonconfig.Watch(config.configFile, baseConfig, &loaded, func(c *daemonconfig.Config) {
+				if c.ResyncPeriod != daemonConfig.ResyncPeriod || c.Workers != daemonConfig.Workers {
+					klog.Warning("Changes to resyncPeriod and workers require a restart to be applied")
+				}
+				applyConfig(c)
+			}, stopCh)
+			if err != nil {
+				klog.Errorf("Error watching config: %s", err.Error())
+			}
+		}()
+	}
+
+	// the daemon is stopped as soon as either the server or the controller
+	// stop, and the exit code reflects whether they both stopped cleanly
+	r := newRunner(cancel)
+	r.run("metrics server", func() error {
+		return podmetrics.Serve(ctx, serverOptions, handlers, podMetrics, stats, devices, vfStats)
+	})
+	if config.otlp.Endpoint != "" {
+		config.otlp.NodeName = config.currentNode
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(podMetrics, stats, devices, vfStats)
+		exporter, err := otlp.New(ctx, config.otlp, registry)
+		if err != nil {
+			klog.Fatalf("Error creating the otlp exporter: %s", err.Error())
+		}
+		r.run("otlp exporter", func() error {
+			return exporter.Run(ctx)
+		})
+	}
+	if config.remoteWrite.URL != "" {
+		// the series are labeled as if they were scraped from the daemon
+		config.remoteWrite.ExternalLabels = map[string]string{
+			"job":      "network-metrics-daemon",
+			"instance": config.currentNode,
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(podMetrics, stats, devices, vfStats)
+		exporter, err := remotewrite.New(config.remoteWrite, registry)
+		if err != nil {
+			klog.Fatalf("Error creating the remote write exporter: %s", err.Error())
+		}
+		r.run("remote write exporter", func() error {
+			return exporter.Run(ctx)
+		})
+	}
+	r.run("controller", func() error {
+		return ctrl.Run(ctx, daemonConfig.Workers, config.drainTimeout)
+	})
+	clean := r.wait()
 
-	if err = ctrl.Run(2, stopCh); err != nil {
-		klog.Fatalf("Error running controller: %s", err.Error())
+	klog.Info("Shut down")
+	if !clean {
+		klog.Flush()
+		os.Exit(1)
 	}
 }
+
+// runner runs the components of the daemon, stopping all of them
+// as soon as one of them stops.
+type runner struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mtx    sync.Mutex
+	failed bool
+}
+
+func newRunner(cancel context.CancelFunc) *runner {
+	return &runner{cancel: cancel}
+}
+
+// run runs the given component in the background.
+func (r *runner) run(name string, f func() error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.cancel()
+		if err := f(); err != nil {
+			klog.Errorf("Error running %s: %s", name, err.Error())
+			r.mtx.Lock()
+			r.failed = true
+			r.mtx.Unlock()
+		}
+	}()
+}
+
+// wait waits for all the components to stop, and returns
+// true if all of them stopped cleanly.
+func (r *runner) wait() bool {
+	r.wg.Wait()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return !r.failed
+}
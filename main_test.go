@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  bool
+		expected bool
+	}{
+		{"clean", false, true},
+		{"failing", true, false},
+	}
+	for _, tst := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := newRunner(cancel)
+		// the first component stops, the other one is stopped with it
+		r.run("first", func() error {
+			if tst.failing {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		r.run("second", func() error {
+			<-ctx.Done()
+			return nil
+		})
+
+		done := make(chan bool)
+		go func() {
+			done <- r.wait()
+		}()
+		select {
+		case clean := <-done:
+			if clean != tst.expected {
+				t.Error(tst.name, "Expected clean", tst.expected, "got", clean)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal(tst.name, "The components did not stop")
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/network-metrics-daemon/pkg/hostdevices"
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/openshift/network-metrics-daemon/pkg/podstats"
+)
+
+// Config is the configuration of the daemon. It can be provided
+// either as a YAML or a JSON file.
+type Config struct {
+	// ResyncPeriod is the resync period of the pod informer. Changing it
+	// requires a restart.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+	// Workers is the number of workers processing the pods. Changing it
+	// requires a restart.
+	Workers int `json:"workers"`
+	// TerminatedPodGracePeriod is how long the series of a terminated pod
+	// are kept, so that they can be joined with its final samples.
+	TerminatedPodGracePeriod metav1.Duration `json:"terminatedPodGracePeriod"`
+	// StatusAnnotations are the annotations the network status is read from,
+	// in order of precedence.
+	StatusAnnotations []string `json:"statusAnnotations"`
+	// StatusAnnotation, when set, is the only annotation the network status
+	// is read from. Deprecated: use StatusAnnotations instead.
+	StatusAnnotation string `json:"statusAnnotation,omitempty"`
+	// Metrics enables or disables the published metrics.
+	Metrics Metrics `json:"metrics"`
+	// Labels contains the options related to the labels of the published metrics.
+	Labels Labels `json:"labels"`
+	// Filters decides which series are published.
+	Filters Filters `json:"filters"`
+	// Relabel contains the rules applied in order to the labels of the published series.
+	Relabel []RelabelRule `json:"relabel,omitempty"`
+}
+
+// Metrics enables or disables each group of published metrics.
+type Metrics struct {
+	NetworkName    bool `json:"networkName"`
+	IP             bool `json:"ip"`
+	Device         bool `json:"device"`
+	InterfaceStats bool `json:"interfaceStats"`
+	// InterfaceState enables the state of the interfaces read
+	// from within the pods' network namespace
+	InterfaceState bool `json:"interfaceState"`
+	// AttachmentMissing enables pod_network_attachment_missing
+	AttachmentMissing bool `json:"attachmentMissing"`
+	// HostDevices enables the inventory of the host network devices
+	HostDevices bool `json:"hostDevices"`
+	// VFStats enables the counters of the virtual functions read from
+	// their physical function
+	VFStats bool `json:"vfStats"`
+}
+
+// Labels contains the options related to the labels of the published metrics.
+type Labels struct {
+	// ConstLabels are added to every published metric.
+	ConstLabels map[string]string `json:"constLabels,omitempty"`
+	// PodLabelAllowlist contains the pod labels added to pod_network_name_info
+	// as label_<sanitized name>. "*" allows all the labels.
+	PodLabelAllowlist []string `json:"podLabelAllowlist,omitempty"`
+	// NADLabelAllowlist contains the network attachment definition labels
+	// added to pod_network_name_info as nad_label_<sanitized name>, when
+	// the definitions are resolved. "*" allows all the labels.
+	NADLabelAllowlist []string `json:"nadLabelAllowlist,omitempty"`
+	// PodUID adds the uid of the pods to the pod level metrics, to tell
+	// apart the pods recreated with the same name.
+	PodUID bool `json:"podUID"`
+}
+
+// reloadDelay is how long the watcher waits for the changes to the config
+// file to settle before reloading it
+const reloadDelay = 200 * time.Millisecond
+
+// Filters decides which series are published. A series is published if it
+// matches no deny rule and, when allow rules are present, at least one allow rule.
+type Filters struct {
+	Allow []FilterRule `json:"allow,omitempty"`
+	Deny  []FilterRule `json:"deny,omitempty"`
+}
+
+// FilterRule matches the series related to a pod network. The regexes are
+// anchored, and empty fields match everything.
+type FilterRule struct {
+	Namespaces  []string `json:"namespaces,omitempty"`
+	NetworkName string   `json:"networkName,omitempty"`
+	Interface   string   `json:"interface,omitempty"`
+}
+
+// RelabelRule changes the labels of the published series. The action is one of:
+//   - drop: drops the series whose label matches the regex
+//   - rename: renames the label to targetLabel
+//   - replace: replaces the value of the label matching the regex with the
+//     replacement, where $1, $2... refer to the regex capture groups
+type RelabelRule struct {
+	Action      string `json:"action"`
+	Label       string `json:"label"`
+	Regex       string `json:"regex,omitempty"`
+	TargetLabel string `json:"targetLabel,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validLabelName returns true if the given label name is valid and not
+// reserved, as the ones starting with __ are.
+func validLabelName(name string) bool {
+	return labelNameRE.MatchString(name) && !strings.HasPrefix(name, "__")
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		ResyncPeriod:             metav1.Duration{Duration: 30 * time.Second},
+		Workers:                  2,
+		TerminatedPodGracePeriod: metav1.Duration{Duration: time.Minute},
+		StatusAnnotations:        []string{podnetwork.Status, podnetwork.LegacyStatus, podnetwork.OVNPodNetworks},
+		Metrics: Metrics{
+			NetworkName:       true,
+			IP:                true,
+			Device:            true,
+			AttachmentMissing: true,
+		},
+	}
+}
+
+// Validate checks the configuration is consistent.
+func (c *Config) Validate() error {
+	if c.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("resyncPeriod must not be negative, got %s", c.ResyncPeriod.Duration)
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", c.Workers)
+	}
+	if c.TerminatedPodGracePeriod.Duration < 0 {
+		return fmt.Errorf("terminatedPodGracePeriod must not be negative, got %s", c.TerminatedPodGracePeriod.Duration)
+	}
+	if len(c.NetworkStatusAnnotations()) == 0 {
+		return fmt.Errorf("statusAnnotations must not be empty")
+	}
+	for _, a := range c.StatusAnnotations {
+		if a == "" {
+			return fmt.Errorf("statusAnnotations must not contain empty annotations")
+		}
+	}
+	for name := range c.Labels.ConstLabels {
+		if !validLabelName(name) {
+			return fmt.Errorf("invalid const label name %q", name)
+		}
+		// the series would be published with the label twice
+		if podmetrics.PublishesLabel(name) || podstats.PublishesLabel(name) || hostdevices.PublishesLabel(name) {
+			return fmt.Errorf("const label %q conflicts with a label of the published metrics", name)
+		}
+	}
+	for _, l := range c.Labels.PodLabelAllowlist {
+		if l == "" {
+			return fmt.Errorf("podLabelAllowlist must not contain empty labels")
+		}
+	}
+	for _, l := range c.Labels.NADLabelAllowlist {
+		if l == "" {
+			return fmt.Errorf("nadLabelAllowlist must not contain empty labels")
+		}
+	}
+	for i, f := range append(append([]FilterRule{}, c.Filters.Allow...), c.Filters.Deny...) {
+		if _, err := compile(f.NetworkName); err != nil {
+			return fmt.Errorf("invalid networkName regex in filter %d: %v", i, err)
+		}
+		if _, err := compile(f.Interface); err != nil {
+			return fmt.Errorf("invalid interface regex in filter %d: %v", i, err)
+		}
+	}
+	for i, r := range c.Relabel {
+		if !validLabelName(r.Label) {
+			return fmt.Errorf("invalid label %q in relabel rule %d", r.Label, i)
+		}
+		if _, err := compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex in relabel rule %d: %v", i, err)
+		}
+		switch podmetrics.RelabelAction(r.Action) {
+		case podmetrics.RelabelDrop, podmetrics.RelabelReplace:
+		case podmetrics.RelabelRename:
+			if !validLabelName(r.TargetLabel) {
+				return fmt.Errorf("invalid target label %q in relabel rule %d", r.TargetLabel, i)
+			}
+			if _, ok := c.Labels.ConstLabels[r.TargetLabel]; ok {
+				return fmt.Errorf("target label %q in relabel rule %d conflicts with a const label", r.TargetLabel, i)
+			}
+		default:
+			return fmt.Errorf("invalid action %q in relabel rule %d", r.Action, i)
+		}
+	}
+	return nil
+}
+
+// NetworkStatusAnnotations returns the annotations the network status
+// is read from, in order of precedence.
+func (c *Config) NetworkStatusAnnotations() []string {
+	if c.StatusAnnotation != "" {
+		return []string{c.StatusAnnotation}
+	}
+	return c.StatusAnnotations
+}
+
+// PodMetricsOptions returns the options to be applied to the pod metrics.
+func (c *Config) PodMetricsOptions() podmetrics.Options {
+	return podmetrics.Options{
+		NetworkName: c.Metrics.NetworkName,
+		IP:          c.Metrics.IP,
+		Device:      c.Metrics.Device,
+		Labels:      c.LabelOptions(),
+
+		AttachmentMissing: c.Metrics.AttachmentMissing,
+		UIDLabel:          c.Labels.PodUID,
+
+		PodLabelAllowlist: c.Labels.PodLabelAllowlist,
+		NADLabelAllowlist: c.Labels.NADLabelAllowlist,
+	}
+}
+
+// LabelOptions returns the options deciding which series are published
+// and their labels. The config is expected to be valid.
+func (c *Config) LabelOptions() podmetrics.LabelOptions {
+	res := podmetrics.LabelOptions{
+		ConstLabels: c.Labels.ConstLabels,
+	}
+	toFilters := func(rules []FilterRule) []podmetrics.FilterRule {
+		filters := make([]podmetrics.FilterRule, 0, len(rules))
+		for _, r := range rules {
+			networkName, _ := compile(r.NetworkName)
+			iface, _ := compile(r.Interface)
+			filters = append(filters, podmetrics.FilterRule{
+				Namespaces:  r.Namespaces,
+				NetworkName: networkName,
+				Interface:   iface,
+			})
+		}
+		return filters
+	}
+	res.Allow = toFilters(c.Filters.Allow)
+	res.Deny = toFilters(c.Filters.Deny)
+	for _, r := range c.Relabel {
+		regex, _ := compile(r.Regex)
+		res.Relabel = append(res.Relabel, podmetrics.RelabelRule{
+			Action:      podmetrics.RelabelAction(r.Action),
+			Label:       r.Label,
+			Regex:       regex,
+			TargetLabel: r.TargetLabel,
+			Replacement: r.Replacement,
+		})
+	}
+	return res
+}
+
+// compile returns the anchored regex for the given expression,
+// or nil if the expression is empty.
+func compile(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// Load reads the configuration file at the given path, applying it on top
+// of the given base configuration, and validates the result.
+func Load(path string, base Config) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	return parse(data, base)
+}
+
+func parse(data []byte, base Config) (*Config, error) {
+	res := base
+	// the maps and slices are copied so that the base is not altered
+	res.Labels.ConstLabels = nil
+	if len(base.Labels.ConstLabels) > 0 {
+		res.Labels.ConstLabels = make(map[string]string)
+		for k, v := range base.Labels.ConstLabels {
+			res.Labels.ConstLabels[k] = v
+		}
+	}
+	res.StatusAnnotations = append([]string(nil), base.StatusAnnotations...)
+	res.Labels.PodLabelAllowlist = append([]string(nil), base.Labels.PodLabelAllowlist...)
+	res.Labels.NADLabelAllowlist = append([]string(nil), base.Labels.NADLabelAllowlist...)
+	res.Filters.Allow = append([]FilterRule(nil), base.Filters.Allow...)
+	res.Filters.Deny = append([]FilterRule(nil), base.Filters.Deny...)
+	res.Relabel = append([]RelabelRule(nil), base.Relabel...)
+
+	if err := yaml.UnmarshalStrict(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	if err := res.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return &res, nil
+}
+
+// Watch watches the configuration file at the given path and invokes onChange
+// with the new configuration each time it differs from the current one, starting
+// from the given configuration loaded at startup. Invalid configurations
+// are logged and ignored. The directory containing the file is watched, so that
+// the atomic symlink swaps performed when a ConfigMap is updated are detected too.
+// It blocks until stopCh is closed.
+func Watch(path string, base Config, current *Config, onChange func(*Config), stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch config file %s: %v", path, err)
+	}
+
+	// the file is read only once the events settle, so that a
+	// partially written file is not loaded
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+
+	var data []byte
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case err := <-watcher.Errors:
+			klog.Warningf("Error watching config file %s: %v", path, err)
+		case <-watcher.Events:
+			reload.Reset(reloadDelay)
+		case <-reload.C:
+			read, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(read, data) {
+				continue
+			}
+			data = read
+			cfg, err := parse(data, base)
+			if err != nil {
+				klog.Errorf("Ignoring new config from %s: %v", path, err)
+				continue
+			}
+			// the file is touched also when other keys of a ConfigMap change
+			if reflect.DeepEqual(cfg, current) {
+				continue
+			}
+			current = cfg
+			klog.Infof("Reloaded config from %s", path)
+			onChange(cfg)
+		}
+	}
+}
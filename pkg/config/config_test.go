@@ -0,0 +1,373 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift/network-metrics-daemon/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var loadTests = []struct {
+	testName    string
+	content     string
+	expected    func() config.Config
+	expectError bool
+}{
+	{
+		"empty file keeps defaults",
+		``,
+		config.Default,
+		false,
+	},
+	{
+		"yaml overrides",
+		`
+resyncPeriod: 1m
+workers: 4
+statusAnnotation: k8s.v1.cni.cncf.io/networks-status
+metrics:
+  ip: false
+  interfaceStats: true
+labels:
+  constLabels:
+    cluster: edge1
+  nadLabelAllowlist: [team]
+  podUID: true
+`,
+		func() config.Config {
+			c := config.Default()
+			c.ResyncPeriod = metav1.Duration{Duration: time.Minute}
+			c.Workers = 4
+			c.StatusAnnotation = "k8s.v1.cni.cncf.io/networks-status"
+			c.Metrics.IP = false
+			c.Metrics.InterfaceStats = true
+			c.Labels.ConstLabels = map[string]string{"cluster": "edge1"}
+			c.Labels.NADLabelAllowlist = []string{"team"}
+			c.Labels.PodUID = true
+			return c
+		},
+		false,
+	},
+	{
+		"json overrides",
+		`{"workers": 3, "terminatedPodGracePeriod": "5m", "metrics": {"device": false}}`,
+		func() config.Config {
+			c := config.Default()
+			c.Workers = 3
+			c.TerminatedPodGracePeriod = metav1.Duration{Duration: 5 * time.Minute}
+			c.Metrics.Device = false
+			return c
+		},
+		false,
+	},
+	{
+		"unknown field",
+		`worker: 3`,
+		nil,
+		true,
+	},
+	{
+		"invalid workers",
+		`workers: 0`,
+		nil,
+		true,
+	},
+	{
+		"negative grace period",
+		`terminatedPodGracePeriod: -1s`,
+		nil,
+		true,
+	},
+	{
+		"negative resync",
+		`resyncPeriod: -1s`,
+		nil,
+		true,
+	},
+	{
+		"status annotations",
+		`statusAnnotations: [k8s.ovn.org/pod-networks]`,
+		func() config.Config {
+			c := config.Default()
+			c.StatusAnnotations = []string{"k8s.ovn.org/pod-networks"}
+			return c
+		},
+		false,
+	},
+	{
+		"no annotations",
+		`statusAnnotations: []`,
+		nil,
+		true,
+	},
+	{
+		"empty annotation",
+		`statusAnnotations: [""]`,
+		nil,
+		true,
+	},
+	{
+		"invalid const label",
+		`labels: {constLabels: {"not-valid": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"reserved const label",
+		`labels: {constLabels: {"__x": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"const label as metric name",
+		`labels: {constLabels: {"__name__": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"const label as variable label",
+		`labels: {constLabels: {"network_name": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"const label as pod label",
+		`labels: {constLabels: {"label_app": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"const label as interface state label",
+		`labels: {constLabels: {"operstate": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"const label as host device label",
+		`labels: {constLabels: {"pf": "value"}}`,
+		nil,
+		true,
+	},
+	{
+		"empty nad label",
+		`labels: {nadLabelAllowlist: [""]}`,
+		nil,
+		true,
+	},
+	{
+		"filters and relabel",
+		`
+filters:
+  allow:
+  - namespaces: [ns1, ns2]
+  deny:
+  - networkName: tenant-.*/.*
+    interface: net[0-9]+
+relabel:
+- action: replace
+  label: network_name
+  regex: tenant-[a-z]+/(.*)
+  replacement: tenant/$1
+- action: rename
+  label: network_name
+  targetLabel: nad
+`,
+		func() config.Config {
+			c := config.Default()
+			c.Filters.Allow = []config.FilterRule{{Namespaces: []string{"ns1", "ns2"}}}
+			c.Filters.Deny = []config.FilterRule{{NetworkName: "tenant-.*/.*", Interface: "net[0-9]+"}}
+			c.Relabel = []config.RelabelRule{
+				{Action: "replace", Label: "network_name", Regex: "tenant-[a-z]+/(.*)", Replacement: "tenant/$1"},
+				{Action: "rename", Label: "network_name", TargetLabel: "nad"},
+			}
+			return c
+		},
+		false,
+	},
+	{
+		"invalid filter regex",
+		`filters: {deny: [{networkName: "tenant-("}]}`,
+		nil,
+		true,
+	},
+	{
+		"invalid relabel action",
+		`relabel: [{action: keep, label: network_name}]`,
+		nil,
+		true,
+	},
+	{
+		"invalid relabel target",
+		`relabel: [{action: rename, label: network_name, targetLabel: "not-valid"}]`,
+		nil,
+		true,
+	},
+	{
+		"reserved relabel target",
+		`relabel: [{action: rename, label: network_name, targetLabel: "__x"}]`,
+		nil,
+		true,
+	},
+	{
+		"relabel target as const label",
+		`
+labels:
+  constLabels: {cluster: a}
+relabel:
+- action: rename
+  label: network_name
+  targetLabel: cluster
+`,
+		nil,
+		true,
+	},
+	{
+		"invalid relabel regex",
+		`relabel: [{action: drop, label: network_name, regex: "("}]`,
+		nil,
+		true,
+	},
+	{
+		"malformed",
+		`workers: [`,
+		nil,
+		true,
+	},
+}
+
+func TestLoad(t *testing.T) {
+	for _, tst := range loadTests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(tst.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := config.Load(path, config.Default())
+		if tst.expectError {
+			if err == nil {
+				t.Error(tst.testName, "Expected error, got", cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(tst.testName, "Unexpected error", err)
+			continue
+		}
+		if expected := tst.expected(); !reflect.DeepEqual(*cfg, expected) {
+			t.Error(tst.testName, "Different result, expected", expected, "got", *cfg)
+		}
+	}
+}
+
+func TestNetworkStatusAnnotations(t *testing.T) {
+	c := config.Default()
+	expected := []string{"k8s.v1.cni.cncf.io/network-status", "k8s.v1.cni.cncf.io/networks-status", "k8s.ovn.org/pod-networks"}
+	if got := c.NetworkStatusAnnotations(); !reflect.DeepEqual(got, expected) {
+		t.Error("Expected", expected, "got", got)
+	}
+
+	c.StatusAnnotation = "k8s.v1.cni.cncf.io/networks-status"
+	if got := c.NetworkStatusAnnotations(); !reflect.DeepEqual(got, []string{c.StatusAnnotation}) {
+		t.Error("Expected the deprecated annotation only, got", got)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := config.Load(filepath.Join(t.TempDir(), "missing.yaml"), config.Default()); err == nil {
+		t.Error("Expected error loading a missing file")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("workers: 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := config.Load(path, config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan *config.Config, 10)
+	stopCh := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- config.Watch(path, config.Default(), loaded, func(c *config.Config) {
+			changes <- c
+		}, stopCh)
+	}()
+
+	// the watcher might not be started yet, so we keep writing until
+	// the expected change is received
+	waitFor := func(content string, workers int) {
+		for i := 0; i < 20; i++ {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case c := <-changes:
+				if c.Workers == 2 {
+					t.Error("Unexpected change to the loaded config", c)
+				}
+				if c.Workers == workers {
+					return
+				}
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+		t.Fatal("No change received for", content)
+	}
+
+	// the content loaded at startup is not notified again
+	if err := os.WriteFile(path, []byte("workers: 2 # touched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("workers: 5", 5)
+
+	// invalid content is ignored, the next valid one is notified
+	if err := os.WriteFile(path, []byte("workers: 0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("workers: 6", 6)
+
+	// a content parsed to the same config is not notified
+	if err := os.WriteFile(path, []byte("workers: 6 # touched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	select {
+	case c := <-changes:
+		t.Error("Unexpected change", c)
+	default:
+	}
+
+	close(stopCh)
+	if err := <-done; err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func TestLabelOptions(t *testing.T) {
+	c := config.Default()
+	c.Filters.Deny = []config.FilterRule{{NetworkName: "tenant-.*"}}
+	c.Relabel = []config.RelabelRule{{Action: "drop", Label: "interface", Regex: "eth0"}}
+	options := c.LabelOptions()
+
+	if len(options.Deny) != 1 || options.Deny[0].NetworkName == nil || options.Deny[0].Interface != nil {
+		t.Fatal("Unexpected deny rules", options.Deny)
+	}
+	// the regexes are anchored
+	if options.Deny[0].NetworkName.MatchString("ns/tenant-a") {
+		t.Error("Expected the network name regex to be anchored")
+	}
+	if !options.Deny[0].NetworkName.MatchString("tenant-a/net") {
+		t.Error("Expected the network name regex to match")
+	}
+	if len(options.Relabel) != 1 || options.Relabel[0].Regex.MatchString("eth01") {
+		t.Error("Unexpected relabel rules", options.Relabel)
+	}
+}
@@ -1,20 +1,32 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"reflect"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 
+	"github.com/openshift/network-metrics-daemon/pkg/daemonmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/health"
 	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
 	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/openshift/network-metrics-daemon/pkg/podstats"
 )
 
 // Controller is the controller implementation for Foo resources
@@ -24,28 +36,94 @@ type Controller struct {
 	podsSynced    cache.InformerSynced
 	indexer       cache.Indexer
 	workqueue     workqueue.RateLimitingInterface
+	metrics       *podmetrics.PodMetrics
+	daemonMetrics *daemonmetrics.Metrics
+	// stats, when set, collects the interface counters from the pods' network namespace
+	stats *podstats.Collector
+
+	mtx sync.RWMutex
+	// statusSources are the sources the network status is read from
+	statusSources podnetwork.Sources
+	// pendingInitialSync contains the pods known when the informer synced
+	// that have not been processed yet, nil until the informer is synced
+	pendingInitialSync map[string]bool
+
+	// terminatedGracePeriod is how long the series of a terminated pod are
+	// kept, so that they can be joined with its final samples
+	terminatedGracePeriod time.Duration
+
+	// failedPods contains the uids of the pods whose network status failed to
+	// be parsed, with the failing annotation, so that they are reported only once
+	failedPods map[types.UID]string
+
+	watch *health.WatchMonitor
+
+	eventBroadcaster record.EventBroadcaster
+	recorder         record.EventRecorder
 }
 
+// invalidNetworkStatus is the reason of the events posted on
+// the pods whose network status annotation is malformed
+const invalidNetworkStatus = "InvalidNetworkStatus"
+
+// defaultTerminatedGracePeriod is how long the series of
+// the terminated pods are kept by default
+const defaultTerminatedGracePeriod = time.Minute
+
+// defaultWatchFailureThreshold is how long the pod watch can be failing
+// before the controller is considered unhealthy
+const defaultWatchFailureThreshold = 5 * time.Minute
+
 // New returns a new controller listening to pods.
 func New(
 	kubeclientset kubernetes.Interface,
 	informer cache.SharedIndexInformer,
+	metrics *podmetrics.PodMetrics,
+	daemonMetrics *daemonmetrics.Metrics,
 	currentNode string) *Controller {
 
 	controller := &Controller{
 		kubeclientset: kubeclientset,
 		indexer:       informer.GetIndexer(),
 		podsSynced:    informer.HasSynced,
-		workqueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Pods"),
+		workqueue: workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(),
+			workqueue.RateLimitingQueueConfig{
+				Name:            "Pods",
+				MetricsProvider: daemonMetrics.WorkqueueMetricsProvider(),
+			}),
+		metrics:       metrics,
+		daemonMetrics: daemonMetrics,
+
+		statusSources:         podnetwork.DefaultSources(),
+		terminatedGracePeriod: defaultTerminatedGracePeriod,
+		failedPods:            make(map[types.UID]string),
+		watch:                 health.NewWatchMonitor(defaultWatchFailureThreshold),
+	}
+
+	controller.eventBroadcaster = record.NewBroadcaster()
+	controller.eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	controller.recorder = controller.eventBroadcaster.NewRecorder(scheme.Scheme,
+		v1.EventSource{Component: "network-metrics-daemon", Host: currentNode})
+
+	err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(context.Background(), r, err)
+		// the watch being closed or expired is part of the normal operations
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.IsResourceExpired(err) || errors.IsGone(err) {
+			return
+		}
+		controller.watch.Failed(err)
+	})
+	if err != nil {
+		klog.Warningf("Failed to set the watch error handler: %v", err)
 	}
 
 	klog.Info("Setting up event handlers")
 
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
+			controller.watch.Succeeded()
 			pod := obj.(*v1.Pod)
-			_, ok := pod.Annotations[podnetwork.Status]
-			if !ok {
+			if !controller.hasNetworks(pod) {
 				return
 			}
 			if pod.Spec.NodeName != currentNode {
@@ -56,8 +134,22 @@ func New(
 		UpdateFunc: func(old, new interface{}) {
 			newPod := new.(*v1.Pod)
 			oldPod := old.(*v1.Pod)
+			if newPod.ResourceVersion == oldPod.ResourceVersion {
+				// periodic resyncs send update events for all the known pods
+				controller.daemonMetrics.InformerResynced()
+			} else {
+				controller.watch.Succeeded()
+			}
 
-			if newPod.Annotations[podnetwork.Status] == oldPod.Annotations[podnetwork.Status] {
+			// the labels are relevant too, as they can be published
+			// according to the pod label allowlist, and so is the phase,
+			// as the networks of a running pod without a network status
+			// are reported missing
+			if !controller.getStatusSources().Changed(oldPod, newPod) &&
+				newPod.Annotations[podnetwork.Networks] == oldPod.Annotations[podnetwork.Networks] &&
+				reflect.DeepEqual(newPod.Labels, oldPod.Labels) &&
+				newPod.Status.Phase == oldPod.Status.Phase &&
+				isTerminated(newPod) == isTerminated(oldPod) {
 				return
 			}
 			if newPod.Spec.NodeName != currentNode {
@@ -66,6 +158,7 @@ func New(
 			controller.enqueuePod(new)
 		},
 		DeleteFunc: func(obj interface{}) {
+			controller.watch.Succeeded()
 			pod, ok := obj.(*v1.Pod)
 			if !ok {
 				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
@@ -89,33 +182,175 @@ func New(
 	return controller
 }
 
+// SetStatsCollector enables the collection of the interface counters of the pods
+// through the given collector.
+func (c *Controller) SetStatsCollector(stats *podstats.Collector) {
+	c.stats = stats
+}
+
+// SetStatusAnnotations changes the annotations the network status is read
+// from, in order of precedence. All the known pods are processed again if
+// the annotations changed.
+func (c *Controller) SetStatusAnnotations(annotations ...string) {
+	sources := podnetwork.SourcesFor(annotations...)
+	c.mtx.Lock()
+	changed := !reflect.DeepEqual(c.statusSources, sources)
+	c.statusSources = sources
+	c.mtx.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, obj := range c.indexer.List() {
+		c.enqueuePod(obj)
+	}
+}
+
+func (c *Controller) getStatusSources() podnetwork.Sources {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.statusSources
+}
+
+// hasNetworks returns true if the given pod reports its network status,
+// or requests additional networks.
+func (c *Controller) hasNetworks(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[podnetwork.Networks]; ok {
+		return true
+	}
+	return c.getStatusSources().Has(pod)
+}
+
+// SetTerminatedGracePeriod changes how long the series of the terminated
+// pods are kept before being deleted.
+func (c *Controller) SetTerminatedGracePeriod(gracePeriod time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.terminatedGracePeriod = gracePeriod
+}
+
+func (c *Controller) getTerminatedGracePeriod() time.Duration {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.terminatedGracePeriod
+}
+
+// SetWatchFailureThreshold changes how long the pod watch can be failing
+// before the controller is considered unhealthy.
+func (c *Controller) SetWatchFailureThreshold(threshold time.Duration) {
+	c.watch.SetThreshold(threshold)
+}
+
+// AddHealthChecks adds the checks related to the controller to the given
+// health and readiness checkers. The controller is ready once the informer
+// is synced and all the pods known at that time were processed, and is
+// neither healthy nor ready when the pod watch has been broken for longer
+// than the watch failure threshold.
+func (c *Controller) AddHealthChecks(healthz, readyz *health.Checker) {
+	healthz.Add("pod-watch", c.watch.Check)
+	readyz.Add("informer-sync", func() error {
+		if !c.podsSynced() {
+			return fmt.Errorf("pod informer not synced")
+		}
+		return nil
+	})
+	readyz.Add("initial-reconciliation", func() error {
+		c.mtx.RLock()
+		defer c.mtx.RUnlock()
+		if c.pendingInitialSync == nil {
+			return fmt.Errorf("waiting for the informer to sync")
+		}
+		if len(c.pendingInitialSync) > 0 {
+			return fmt.Errorf("%d pods not processed yet", len(c.pendingInitialSync))
+		}
+		return nil
+	})
+	readyz.Add("pod-watch", c.watch.Check)
+}
+
+// trackInitialSync records the pods that need to be processed
+// for the initial reconciliation to be complete.
+func (c *Controller) trackInitialSync() {
+	pending := make(map[string]bool)
+	for _, obj := range c.indexer.List() {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			continue
+		}
+		if !c.hasNetworks(pod) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(pod)
+		if err != nil {
+			continue
+		}
+		pending[key] = true
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pendingInitialSync = pending
+}
+
+// initialSyncProcessed records the given pod as processed.
+func (c *Controller) initialSyncProcessed(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.pendingInitialSync, key)
+}
+
 // Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until stopCh
-// is closed, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
-func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+// as syncing informer caches and starting workers. It will block until ctx
+// is cancelled, at which point it will shutdown the workqueue and wait for
+// workers to finish processing their current work items, returning an error
+// if they do not within the drain timeout.
+func (c *Controller) Run(ctx context.Context, threadiness int, drainTimeout time.Duration) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	defer c.eventBroadcaster.Shutdown()
 
 	// Start the informer factories to begin populating the informer caches
 	klog.Info("Starting pod controller")
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.podsSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.podsSynced); !ok {
+		if ctx.Err() != nil {
+			// stopped while starting, i.e. by a SIGTERM
+			klog.Info("Stopped before the caches synced")
+			return nil
+		}
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	c.trackInitialSync()
 
 	klog.Info("Starting workers")
+	var wg sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, ctx.Done())
+		}()
 	}
 
 	klog.Info("Started workers")
-	<-stopCh
+	<-ctx.Done()
 	klog.Info("Shutting down workers")
+	c.workqueue.ShutDown()
 
-	return nil
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		klog.Info("Workers stopped")
+		return nil
+	case <-time.After(drainTimeout):
+		return fmt.Errorf("workers did not stop within %s", drainTimeout)
+	}
 }
 
 func (c *Controller) runWorker() {
@@ -135,20 +370,30 @@ func (c *Controller) processNextWorkItem() bool {
 	// We wrap this block in a func so we can defer c.workqueue.Done.
 	err := func(obj interface{}) error {
 		defer c.workqueue.Done(obj)
-		var key string
+		var item podItem
 		var ok bool
-		if key, ok = obj.(string); !ok {
+		if item, ok = obj.(podItem); !ok {
 			c.workqueue.Forget(obj)
-			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			utilruntime.HandleError(fmt.Errorf("expected pod item in workqueue but got %#v", obj))
 			return nil
 		}
-		if err := c.podHandler(key); err != nil {
-			c.workqueue.AddRateLimited(key)
-			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		start := time.Now()
+		err := c.podHandler(item)
+		c.daemonMetrics.ObserveSync(start, err)
+		// failing pods must not prevent the controller from being ready
+		c.initialSyncProcessed(item.key)
+		if podnetwork.IsParseError(err) {
+			// the pod is processed again only when the annotation changes
+			c.workqueue.Forget(obj)
+			return fmt.Errorf("error syncing '%s': %s, not requeuing", item, err.Error())
+		}
+		if err != nil {
+			c.workqueue.AddRateLimited(item)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", item, err.Error())
 		}
 
 		c.workqueue.Forget(obj)
-		klog.Infof("Successfully synced '%s'", key)
+		klog.Infof("Successfully synced '%s'", item)
 		return nil
 	}(obj)
 
@@ -161,7 +406,8 @@ func (c *Controller) processNextWorkItem() bool {
 }
 
 // podHandler receives a pod and updates the related pod network metrics
-func (c *Controller) podHandler(key string) error {
+func (c *Controller) podHandler(item podItem) error {
+	key := item.key
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -172,14 +418,14 @@ func (c *Controller) podHandler(key string) error {
 	// Get the Pod resource with this namespace/name
 	if err != nil {
 		if errors.IsNotFound(err) {
-			podmetrics.DeleteAllForPod(name, namespace)
+			c.deleteAllForPod(name, namespace, item.uid)
 			return nil
 		}
 		return err
 	}
 
 	if !exists {
-		podmetrics.DeleteAllForPod(name, namespace)
+		c.deleteAllForPod(name, namespace, item.uid)
 		return nil
 	}
 
@@ -188,21 +434,157 @@ func (c *Controller) podHandler(key string) error {
 		utilruntime.HandleError(fmt.Errorf("invalid object for key: %s", key))
 		return nil
 	}
+	if pod.UID != item.uid {
+		// the pod was deleted and recreated with the same name, the new
+		// one being processed through its own item
+		c.deleteAllForPod(name, namespace, item.uid)
+		return nil
+	}
 
 	klog.Infof("Received pod '%s'", pod.Name)
-	networks, err := podnetwork.Get(pod)
+	if since, ok := terminatedSince(pod); ok {
+		// the series are kept for the grace period, so that the pod
+		// can still be joined with its final samples
+		if remaining := c.getTerminatedGracePeriod() - time.Since(since); remaining > 0 {
+			c.workqueue.AddAfter(item, remaining)
+			return nil
+		}
+		klog.Infof("Pod '%s' terminated, deleting its metrics", pod.Name)
+		c.deleteAllForPod(name, namespace, pod.UID)
+		return nil
+	}
+
+	statusSources := c.getStatusSources()
+	networks, err := statusSources.Get(pod)
 	if err != nil {
+		c.metrics.StatusParseFailed(pod.UID)
+		c.daemonMetrics.StatusParseFailed(pod.Namespace, pod.UID)
+		if podnetwork.IsParseError(err) {
+			// the series of the previous annotation are not valid anymore
+			c.deleteSeriesForPod(pod.Name, pod.Namespace, pod.UID)
+			_, annotation, _ := statusSources.Lookup(pod)
+			c.reportParseFailure(pod, annotation, err)
+		}
 		return err
 	}
+	c.clearParseFailure(pod.UID)
 
-	// As an interface might have been removed from the pod (or changed)
-	// and eventually re-add them, as the chance of having the networks changed is
-	// pretty low
-	podmetrics.DeleteAllForPod(name, namespace)
-	podmetrics.UpdateForPod(pod.Name, pod.Namespace, networks)
+	c.metrics.UpdateForPod(pod.Name, pod.Namespace, pod.UID, pod.Labels, networks)
+	c.updateMissing(pod, statusSources, networks)
+	if c.stats != nil {
+		c.stats.UpdateForPod(pod.Name, pod.Namespace, pod.UID, networks)
+	}
 	return nil
 }
 
+// isTerminated returns true if the given pod completed, or if its sandbox
+// was torn down, so that its networks do not exist anymore.
+func isTerminated(pod *v1.Pod) bool {
+	_, ok := terminatedSince(pod)
+	return ok
+}
+
+// terminatedSince returns when the given pod terminated. The time is taken
+// from the pod status, so that it does not change when processed again.
+func terminatedSince(pod *v1.Pod) (time.Time, bool) {
+	terminal := pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+	for _, condition := range pod.Status.Conditions {
+		// the sandbox is not ready either before being created or after
+		// being torn down, and only the latter makes the pod terminated
+		if condition.Type != v1.PodReadyToStartContainers || condition.Status != v1.ConditionFalse ||
+			(!terminal && pod.DeletionTimestamp == nil) {
+			continue
+		}
+		if !condition.LastTransitionTime.IsZero() {
+			return condition.LastTransitionTime.Time, true
+		}
+		if pod.DeletionTimestamp != nil {
+			return pod.DeletionTimestamp.Time, true
+		}
+	}
+	if !terminal {
+		return time.Time{}, false
+	}
+
+	var finished time.Time
+	for _, status := range append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		if t := status.State.Terminated; t != nil && t.FinishedAt.After(finished) {
+			finished = t.FinishedAt.Time
+		}
+	}
+	if !finished.IsZero() {
+		return finished, true
+	}
+	return pod.CreationTimestamp.Time, true
+}
+
+// updateMissing publishes the networks requested by the given pod that are
+// missing from its network status. Until the network status is reported,
+// the networks are considered missing only once the pod is running, as they
+// are still being attached while the pod is being created.
+func (c *Controller) updateMissing(pod *v1.Pod, statusSources podnetwork.Sources, networks []podnetwork.Network) {
+	requested, err := podnetwork.GetRequested(pod)
+	if err != nil {
+		klog.Warningf("Ignoring the requested networks: %v", err)
+		requested = nil
+	}
+	if _, _, ok := statusSources.Lookup(pod); !ok && pod.Status.Phase != v1.PodRunning {
+		requested = nil
+	}
+	c.metrics.UpdateMissingForPod(pod.Name, pod.Namespace, pod.UID, podnetwork.Missing(requested, networks))
+}
+
+// reportParseFailure posts a warning event on the given pod, and counts it as
+// permanently failed, unless already done for the same annotation.
+func (c *Controller) reportParseFailure(pod *v1.Pod, annotation string, err error) {
+	c.mtx.Lock()
+	failed, ok := c.failedPods[pod.UID]
+	c.failedPods[pod.UID] = annotation
+	c.mtx.Unlock()
+	if ok && failed == annotation {
+		return
+	}
+
+	c.recorder.Eventf(pod, v1.EventTypeWarning, invalidNetworkStatus,
+		"Failed to parse the network status annotation, no network metrics are published for the pod: %v", err)
+	c.daemonMetrics.PodFailedPermanently()
+}
+
+// clearParseFailure forgets the parse failure of the given pod, if any.
+func (c *Controller) clearParseFailure(uid types.UID) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.failedPods, uid)
+}
+
+// deleteAllForPod stops publishing all the metrics related to the given pod,
+// unless they belong to a new pod with the same name, and forgets its failures.
+func (c *Controller) deleteAllForPod(name, namespace string, uid types.UID) {
+	c.clearParseFailure(uid)
+	c.deleteSeriesForPod(name, namespace, uid)
+}
+
+// deleteSeriesForPod stops publishing all the metrics related to the given
+// pod, unless they belong to a new pod with the same name.
+func (c *Controller) deleteSeriesForPod(name, namespace string, uid types.UID) {
+	c.metrics.DeleteAllForPod(name, namespace, uid)
+	if c.stats != nil {
+		c.stats.DeleteForPod(name, namespace, uid)
+	}
+}
+
+// podItem is the item of the workqueue identifying a pod. As a pod
+// can be deleted and recreated with the same name, the items carry
+// the uid of the pod, so that each incarnation is processed separately.
+type podItem struct {
+	key string
+	uid types.UID
+}
+
+func (i podItem) String() string {
+	return fmt.Sprintf("%s (%s)", i.key, i.uid)
+}
+
 func (c *Controller) enqueuePod(obj interface{}) {
 	var key string
 	var err error
@@ -210,5 +592,10 @@ func (c *Controller) enqueuePod(obj interface{}) {
 		utilruntime.HandleError(err)
 		return
 	}
-	c.workqueue.Add(key)
+	pod, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(podItem{key: key, uid: pod.GetUID()})
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -11,9 +12,13 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/openshift/network-metrics-daemon/pkg/daemonmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/health"
 	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
 	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
@@ -35,12 +40,15 @@ type fixture struct {
 	podsLister      []*v1.Pod
 	kubeobjects     []runtime.Object
 	expectedMetrics string
+	metrics         *podmetrics.PodMetrics
+	daemonMetrics   *daemonmetrics.Metrics
 }
 
 func newFixture(t *testing.T) *fixture {
 	f := &fixture{}
 	f.t = t
 	f.kubeobjects = []runtime.Object{}
+	f.metrics = podmetrics.New()
 	return f
 }
 
@@ -49,6 +57,7 @@ func newPod(name, namespace string, networkAnnotation string) *v1.Pod {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			UID:       types.UID(namespace + "-" + name),
 			Annotations: map[string]string{
 				podnetwork.Status: networkAnnotation,
 			},
@@ -75,7 +84,7 @@ func (f *fixture) newController() (*Controller, cache.SharedInformer) {
 		0, //Skip resync
 		cache.Indexers{},
 	)
-	c := New(f.kubeclient, informer, "NodeName")
+	c := New(f.kubeclient, informer, f.metrics, f.daemonMetrics, "NodeName")
 
 	c.podsSynced = alwaysReady
 
@@ -117,14 +126,13 @@ func TestPublishesMetric(t *testing.T) {
 	`
 
 	f.run(func(c *Controller, informer cache.SharedInformer) {
-		c.podHandler(getKey(pod, t))
+		c.podHandler(getItem(pod, t))
 	})
 
-	err := promtestutil.CollectAndCompare(podmetrics.NetAttachDefPerPod, strings.NewReader(metadata+f.expectedMetrics))
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(metadata+f.expectedMetrics), "pod_network_name_info")
 	if err != nil {
 		t.Error("Failed to collect metrics", err)
 	}
-	podmetrics.NetAttachDefPerPod.Reset()
 }
 
 func TestDeletesMetric(t *testing.T) {
@@ -146,28 +154,551 @@ func TestDeletesMetric(t *testing.T) {
 
 	f.run(func(c *Controller, informer cache.SharedInformer) {
 		// send pod, then make it disappear simulating a delete
-		c.podHandler(getKey(pod, t))
+		c.podHandler(getItem(pod, t))
 		f.podsLister = []*v1.Pod{}
 		f.kubeobjects = []runtime.Object{}
 		indxr := informer.GetStore()
 		for _, p := range indxr.List() {
 			indxr.Delete(p)
 		}
-		c.podHandler(getKey(pod, t))
+		c.podHandler(getItem(pod, t))
 	})
 
-	err := promtestutil.CollectAndCompare(podmetrics.NetAttachDefPerPod, strings.NewReader(metadata+f.expectedMetrics))
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(metadata+f.expectedMetrics), "pod_network_name_info")
 	if err != nil {
 		t.Error("Failed to collect metrics", err)
 	}
-	podmetrics.NetAttachDefPerPod.Reset()
 }
 
-func getKey(pod *v1.Pod, t *testing.T) string {
+func TestStatusAnnotationChange(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", "")
+	pod.Annotations = map[string]string{
+		"k8s.v1.cni.cncf.io/networks-status": `[{
+		"name": "kindnet",
+		"interface": "eth0"
+	}]`,
+	}
+	f.podsLister = append(f.podsLister, pod)
+	f.kubeobjects = append(f.kubeobjects, pod)
+	f.expectedMetrics = `
+	pod_network_name_info{interface="eth0",namespace="namespace",network_name="kindnet",pod="podname"} 0
+	`
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		c.SetStatusAnnotations(podnetwork.Status)
+		c.podHandler(getItem(pod, t))
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info"); count != 0 {
+			t.Error("Expected no metrics with the network status annotation only, got", count)
+		}
+		drainQueue(c)
+
+		c.SetStatusAnnotations(podnetwork.LegacyStatus)
+		if c.workqueue.Len() != 1 {
+			t.Error("Expected the pod to be enqueued, queue length", c.workqueue.Len())
+		}
+		c.podHandler(getItem(pod, t))
+	})
+
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(metadata+f.expectedMetrics), "pod_network_name_info")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestMissingAttachments(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0",
+		"default": true
+	}, {
+		"name": "namespace/macvlan",
+		"interface": "net1"
+	}]`)
+	pod.Annotations[podnetwork.Networks] = "macvlan@net1, namespace1/sriov"
+	pending := newPod("pending", "namespace", "")
+	delete(pending.Annotations, podnetwork.Status)
+	pending.Annotations[podnetwork.Networks] = `[{"name": "macvlan"}]`
+	pending.Status.Phase = v1.PodPending
+	f.kubeobjects = append(f.kubeobjects, pod, pending)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		waitForQueue(t, c, 2)
+		drainQueue(c)
+		c.podHandler(getItem(pod, t))
+		c.podHandler(getItem(pending, t))
+
+		expected := `
+		# HELP pod_network_attachment_missing Number of attachments to the network requested by the pod and missing from its network status.
+		# TYPE pod_network_attachment_missing gauge
+		pod_network_attachment_missing{namespace="namespace",network_name="namespace1/sriov",pod="podname"} 1
+		`
+		err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(expected), "pod_network_attachment_missing")
+		if err != nil {
+			t.Error("Failed to collect metrics", err)
+		}
+
+		// the pod starts running without its network status being reported
+		running := pending.DeepCopy()
+		running.Status.Phase = v1.PodRunning
+		_, err = f.kubeclient.CoreV1().Pods(running.Namespace).UpdateStatus(context.Background(), running, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatal("Failed to update the pod", err)
+		}
+		waitForQueue(t, c, 1)
+		c.processNextWorkItem()
+
+		expected = `
+		# HELP pod_network_attachment_missing Number of attachments to the network requested by the pod and missing from its network status.
+		# TYPE pod_network_attachment_missing gauge
+		pod_network_attachment_missing{namespace="namespace",network_name="namespace/macvlan",pod="pending"} 1
+		pod_network_attachment_missing{namespace="namespace",network_name="namespace1/sriov",pod="podname"} 1
+		`
+		err = promtestutil.CollectAndCompare(f.metrics, strings.NewReader(expected), "pod_network_attachment_missing")
+		if err != nil {
+			t.Error("Failed to collect metrics", err)
+		}
+	})
+}
+
+func TestTerminatedPod(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0"
+	}]`)
+	f.podsLister = append(f.podsLister, pod)
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		item := getItem(pod, t)
+		c.podHandler(item)
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info"); count != 1 {
+			t.Fatal("Expected the running pod to be published, got", count)
+		}
+
+		finished := time.Now().Add(-10 * time.Second)
+		completed := pod.DeepCopy()
+		completed.Status.Phase = v1.PodSucceeded
+		completed.Status.ContainerStatuses = []v1.ContainerStatus{{
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)}},
+		}}
+		c.indexer.Update(completed)
+
+		// the series are kept during the grace period
+		c.SetTerminatedGracePeriod(time.Minute)
+		c.podHandler(item)
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info"); count != 1 {
+			t.Error("Expected the pod to be published during the grace period, got", count)
+		}
+
+		c.SetTerminatedGracePeriod(5 * time.Second)
+		c.podHandler(item)
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info"); count != 0 {
+			t.Error("Expected the terminated pod not to be published, got", count)
+		}
+	})
+}
+
+var terminatedTests = []struct {
+	testName   string
+	phase      v1.PodPhase
+	deleting   bool
+	sandbox    v1.ConditionStatus
+	terminated bool
+}{
+	{"running", v1.PodRunning, false, v1.ConditionTrue, false},
+	{"creating", v1.PodPending, false, v1.ConditionFalse, false},
+	{"terminating", v1.PodRunning, true, v1.ConditionTrue, false},
+	{"sandboxtorndown", v1.PodRunning, true, v1.ConditionFalse, true},
+	{"succeeded", v1.PodSucceeded, false, v1.ConditionTrue, true},
+	{"failed", v1.PodFailed, false, v1.ConditionFalse, true},
+}
+
+func TestIsTerminated(t *testing.T) {
+	for _, tst := range terminatedTests {
+		pod := newPod("podname", "namespace", "")
+		pod.Status.Phase = tst.phase
+		pod.Status.Conditions = []v1.PodCondition{{
+			Type:               v1.PodReadyToStartContainers,
+			Status:             tst.sandbox,
+			LastTransitionTime: metav1.Now(),
+		}}
+		if tst.deleting {
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+		}
+		if res := isTerminated(pod); res != tst.terminated {
+			t.Error(tst.testName, "Expected terminated", tst.terminated, "got", res)
+		}
+	}
+}
+
+func TestRecreatedPod(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "namespace/first",
+		"interface": "net1"
+	}]`)
+	pod.UID = "uid1"
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			t.Fatal("Failed to sync the informer")
+		}
+		waitForQueue(t, c, 1)
+		c.processNextWorkItem()
+
+		// the pod is deleted and recreated with the same name
+		recreated := newPod("podname", "namespace", `[{
+			"name": "namespace/second",
+			"interface": "net1"
+		}]`)
+		recreated.UID = "uid2"
+		if err := f.kubeclient.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+			t.Fatal("Failed to delete pod", err)
+		}
+		if _, err := f.kubeclient.CoreV1().Pods(pod.Namespace).Create(context.Background(), recreated, metav1.CreateOptions{}); err != nil {
+			t.Fatal("Failed to create pod", err)
+		}
+		waitForQueue(t, c, 2)
+
+		// the delete of the old pod is processed after the new pod
+		deleted, _ := c.workqueue.Get()
+		added, _ := c.workqueue.Get()
+		if deleted.(podItem).uid != "uid1" || added.(podItem).uid != "uid2" {
+			t.Fatal("Expected an item for each pod, got", deleted, added)
+		}
+		c.podHandler(added.(podItem))
+		c.podHandler(deleted.(podItem))
+	})
+
+	expected := `
+	pod_network_name_info{interface="net1",namespace="namespace",network_name="namespace/second",pod="podname"} 0
+	`
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(metadata+expected), "pod_network_name_info")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestLabelChangeEnqueuesPod(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0"
+	}]`)
+	pod.Labels = map[string]string{"app": "app1"}
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			t.Fatal("Failed to sync the informer")
+		}
+		waitForQueue(t, c, 1)
+		key, _ := c.workqueue.Get()
+		c.workqueue.Forget(key)
+		c.workqueue.Done(key)
+
+		// a change to an unrelated field is ignored
+		updated := pod.DeepCopy()
+		updated.Spec.Hostname = "hostname"
+		if _, err := f.kubeclient.CoreV1().Pods(pod.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+			t.Fatal("Failed to update pod", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if c.workqueue.Len() != 0 {
+			t.Error("Expected the pod not to be enqueued, queue length", c.workqueue.Len())
+		}
+
+		updated = updated.DeepCopy()
+		updated.Labels["app"] = "app2"
+		if _, err := f.kubeclient.CoreV1().Pods(pod.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+			t.Fatal("Failed to update pod", err)
+		}
+		waitForQueue(t, c, 1)
+		c.podHandler(getItem(pod, t))
+	})
+
+	f.metrics.SetOptions(podmetrics.Options{NetworkName: true, PodLabelAllowlist: []string{"app"}})
+	expected := `
+	pod_network_name_info{interface="eth0",label_app="app2",namespace="namespace",network_name="kindnet",pod="podname"} 0
+	`
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(metadata+expected), "pod_network_name_info")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestCountsParseErrors(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{"name": "kindnet",`)
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		waitForQueue(t, c, 1)
+		c.processNextWorkItem()
+		// retrying can't fix the annotation, so the failure is counted once
+		if c.workqueue.NumRequeues(getItem(pod, t)) != 0 {
+			t.Error("Expected the malformed pod not to be requeued")
+		}
+	})
+
+	expected := `
+	# HELP node_network_status_parse_errors_total Number of failures parsing the network status annotation of the pods on the node.
+	# TYPE node_network_status_parse_errors_total counter
+	node_network_status_parse_errors_total 1
+	`
+	err := promtestutil.CollectAndCompare(f.metrics, strings.NewReader(expected), "node_network_status_parse_errors_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0"
+	}]`)
+	malformed := newPod("malformed", "namespace", `[{"name": "kindnet",`)
+	f.kubeobjects = append(f.kubeobjects, pod, malformed)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		healthz, readyz := health.NewChecker(), health.NewChecker()
+		c.AddHealthChecks(healthz, readyz)
+		if res := readyz.Run(); res.Healthy {
+			t.Error("Expected not to be ready before the informer is synced")
+		}
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			t.Fatal("Failed to sync the informer")
+		}
+		c.trackInitialSync()
+		waitForQueue(t, c, 2)
+
+		c.processNextWorkItem()
+		if res := readyz.Run(); res.Healthy {
+			t.Error("Expected not to be ready with a pod not processed yet")
+		}
+		// a pod failing to be processed does not block the readiness
+		c.processNextWorkItem()
+		if res := readyz.Run(); !res.Healthy {
+			t.Error("Expected to be ready, got", res)
+		}
+		if res := healthz.Run(); !res.Healthy {
+			t.Error("Expected to be healthy, got", res)
+		}
+
+		// a watch broken for longer than the threshold fails both
+		c.SetWatchFailureThreshold(time.Millisecond)
+		c.watch.Failed(errors.New("connection refused"))
+		time.Sleep(10 * time.Millisecond)
+		if res := healthz.Run(); res.Healthy {
+			t.Error("Expected not to be healthy with the watch failing")
+		}
+		if res := readyz.Run(); res.Healthy {
+			t.Error("Expected not to be ready with the watch failing")
+		}
+	})
+}
+
+func TestMalformedStatusDeletesMetrics(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0",
+		"ips": [
+			"10.244.0.10"
+		]
+	}]`)
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		c.recorder = record.NewFakeRecorder(10)
+		item := getItem(pod, t)
+		waitForQueue(t, c, 1)
+		c.podHandler(item)
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info", "pod_network_ip_info"); count != 2 {
+			t.Fatal("Expected the pod to be published, got", count)
+		}
+
+		malformed := pod.DeepCopy()
+		malformed.Annotations[podnetwork.Status] = `[{"name": "kindnet",`
+		c.indexer.Update(malformed)
+		if err := c.podHandler(item); !podnetwork.IsParseError(err) {
+			t.Error("Expected a parse error, got", err)
+		}
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info", "pod_network_ip_info"); count != 0 {
+			t.Error("Expected the series of the malformed pod to be deleted, got", count)
+		}
+	})
+}
+
+func TestMalformedStatusEvent(t *testing.T) {
+	f := newFixture(t)
+	malformed := newPod("malformed", "namespace", `[{"name": "kindnet",`)
+	f.kubeobjects = append(f.kubeobjects, malformed)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		recorder := record.NewFakeRecorder(10)
+		c.recorder = recorder
+		item := getItem(malformed, t)
+
+		for i := 0; i < 2; i++ {
+			waitForQueue(t, c, 1)
+			c.processNextWorkItem()
+			if c.workqueue.NumRequeues(item) != 0 {
+				t.Error("Expected the malformed pod not to be requeued")
+			}
+			if c.workqueue.Len() != 0 {
+				t.Error("Expected an empty queue, got", c.workqueue.Len())
+			}
+			c.workqueue.Add(item)
+		}
+
+		if len(recorder.Events) != 1 {
+			t.Fatal("Expected one event, got", len(recorder.Events))
+		}
+		if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+invalidNetworkStatus) {
+			t.Error("Unexpected event", event)
+		}
+
+		// a pod fixed and broken again is reported again
+		c.clearParseFailure(malformed.UID)
+		waitForQueue(t, c, 1)
+		c.processNextWorkItem()
+		if len(recorder.Events) != 1 {
+			t.Error("Expected a new event, got", len(recorder.Events))
+		}
+	})
+}
+
+func TestRunStopsWorkers(t *testing.T) {
+	f := newFixture(t)
+	pod := newPod("podname", "namespace", `[{
+		"name": "kindnet",
+		"interface": "eth0"
+	}]`)
+	f.kubeobjects = append(f.kubeobjects, pod)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- c.Run(ctx, 2, 5*time.Second)
+		}()
+
+		for i := 0; i < 100 && promtestutil.CollectAndCount(f.metrics, "pod_network_name_info") == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if count := promtestutil.CollectAndCount(f.metrics, "pod_network_name_info"); count != 1 {
+			t.Fatal("Expected the pod to be processed, metrics", count)
+		}
+
+		cancel()
+		select {
+		case err := <-runErr:
+			if err != nil {
+				t.Error("Expected a clean shutdown, got", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after the context was cancelled")
+		}
+		if !c.workqueue.ShuttingDown() {
+			t.Error("Expected the workqueue to be shut down")
+		}
+	})
+}
+
+func TestRunStoppedBeforeSync(t *testing.T) {
+	f := newFixture(t)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		c.podsSynced = func() bool { return false }
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- c.Run(ctx, 2, 5*time.Second)
+		}()
+
+		// a shutdown while starting is not a failure
+		cancel()
+		select {
+		case err := <-runErr:
+			if err != nil {
+				t.Error("Expected a clean shutdown, got", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after the context was cancelled")
+		}
+	})
+}
+
+func TestRunDrainTimeout(t *testing.T) {
+	f := newFixture(t)
+	malformed := newPod("malformed", "namespace", `[{"name": "kindnet",`)
+	f.kubeobjects = append(f.kubeobjects, malformed)
+
+	f.run(func(c *Controller, informer cache.SharedInformer) {
+		// the worker is blocked posting the event of the malformed pod
+		// until the end of the test
+		recorder := record.NewFakeRecorder(0)
+		c.recorder = recorder
+		waitForQueue(t, c, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- c.Run(ctx, 1, 100*time.Millisecond)
+		}()
+		waitForQueue(t, c, 0)
+		defer func() { <-recorder.Events }()
+
+		cancel()
+		select {
+		case err := <-runErr:
+			if err == nil {
+				t.Error("Expected an error with the worker not stopping")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after the drain timeout")
+		}
+	})
+}
+
+func waitForQueue(t *testing.T, c *Controller, length int) {
+	for i := 0; i < 100; i++ {
+		if c.workqueue.Len() == length {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected queue length", length, "got", c.workqueue.Len())
+}
+
+func drainQueue(c *Controller) {
+	for c.workqueue.Len() > 0 {
+		obj, _ := c.workqueue.Get()
+		c.workqueue.Forget(obj)
+		c.workqueue.Done(obj)
+	}
+}
+
+func getItem(pod *v1.Pod, t *testing.T) podItem {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(pod)
 	if err != nil {
 		t.Errorf("Unexpected error getting key for foo %v: %v", pod.Name, err)
-		return ""
+		return podItem{}
 	}
-	return key
+	return podItem{key: key, uid: pod.UID}
 }
@@ -0,0 +1,131 @@
+package daemonmetrics
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/openshift/network-metrics-daemon/pkg/exposition"
+)
+
+const namespace = "network_metrics_daemon"
+
+// Metrics contains the metrics describing the health of the daemon itself,
+// served separately from the network metrics. All the methods can be
+// called on a nil Metrics, in which case they do nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	syncDuration     *prometheus.HistogramVec
+	parseErrors      *prometheus.CounterVec
+	failedPods       prometheus.Counter
+	lastResync       prometheus.Gauge
+	workqueueMetrics *workqueueMetricsProvider
+}
+
+// New returns the daemon metrics, registered in a dedicated registry
+// together with the go and process collectors.
+func New(build string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pod_sync_duration_seconds",
+			Help:      "Time spent processing a pod, by result.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"result"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "status_parse_errors_total",
+			Help:      "Number of failures parsing the network status annotation, by namespace.",
+		}, []string{"namespace"}),
+		failedPods: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pods_failed_permanently_total",
+			Help:      "Number of pods not retried because their network status annotation is malformed.",
+		}),
+		lastResync: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "informer_last_resync_timestamp_seconds",
+			Help:      "Time of the last resync of the pod informer, in seconds since the epoch.",
+		}),
+		workqueueMetrics: newWorkqueueMetricsProvider(),
+	}
+
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "build_info",
+		Help:        "A metric with a constant '1' value labeled by the version of the daemon and the go version it was built with.",
+		ConstLabels: prometheus.Labels{"version": build, "goversion": runtime.Version()},
+	})
+	buildInfo.Set(1)
+
+	m.registry.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+		buildInfo,
+		m.syncDuration,
+		m.parseErrors,
+		m.failedPods,
+		m.lastResync,
+	)
+	m.workqueueMetrics.register(m.registry)
+	return m
+}
+
+// Handler returns the handler serving the daemon metrics.
+func (m *Metrics) Handler() http.Handler {
+	return exposition.Handler(m.registry)
+}
+
+// WorkqueueMetricsProvider returns the provider of the workqueue metrics,
+// or nil if m is nil.
+func (m *Metrics) WorkqueueMetricsProvider() workqueue.MetricsProvider {
+	if m == nil {
+		return nil
+	}
+	return m.workqueueMetrics
+}
+
+// ObserveSync records the time spent processing a pod.
+func (m *Metrics) ObserveSync(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.syncDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// StatusParseFailed records a failure parsing the network status of a
+// pod in the given namespace. The uid of the pod is attached as exemplar.
+func (m *Metrics) StatusParseFailed(namespace string, uid types.UID) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.WithLabelValues(namespace).(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"pod_uid": string(uid)})
+}
+
+// PodFailedPermanently records a pod that is not retried until its
+// network status annotation changes.
+func (m *Metrics) PodFailedPermanently() {
+	if m == nil {
+		return
+	}
+	m.failedPods.Inc()
+}
+
+// InformerResynced records a resync of the pod informer.
+func (m *Metrics) InformerResynced() {
+	if m == nil {
+		return
+	}
+	m.lastResync.SetToCurrentTime()
+}
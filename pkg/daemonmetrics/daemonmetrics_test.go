@@ -0,0 +1,106 @@
+package daemonmetrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveSync(time.Now(), nil)
+	m.StatusParseFailed("namespace", "uid")
+	m.PodFailedPermanently()
+	m.InformerResynced()
+	if m.WorkqueueMetricsProvider() != nil {
+		t.Error("Expected no workqueue metrics provider")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New("abcdef")
+	m.StatusParseFailed("namespace1", "uid1")
+	m.StatusParseFailed("namespace1", "uid1")
+	m.StatusParseFailed("namespace2", "uid2")
+	m.PodFailedPermanently()
+	m.ObserveSync(time.Now(), nil)
+	m.ObserveSync(time.Now(), errors.New("failed"))
+	m.InformerResynced()
+
+	expected := `
+	# HELP network_metrics_daemon_build_info A metric with a constant '1' value labeled by the version of the daemon and the go version it was built with.
+	# TYPE network_metrics_daemon_build_info gauge
+	network_metrics_daemon_build_info{goversion="` + runtime.Version() + `",version="abcdef"} 1
+	# HELP network_metrics_daemon_pods_failed_permanently_total Number of pods not retried because their network status annotation is malformed.
+	# TYPE network_metrics_daemon_pods_failed_permanently_total counter
+	network_metrics_daemon_pods_failed_permanently_total 1
+	# HELP network_metrics_daemon_status_parse_errors_total Number of failures parsing the network status annotation, by namespace.
+	# TYPE network_metrics_daemon_status_parse_errors_total counter
+	network_metrics_daemon_status_parse_errors_total{namespace="namespace1"} 2
+	network_metrics_daemon_status_parse_errors_total{namespace="namespace2"} 1
+	`
+	err := testutil.GatherAndCompare(m.registry, strings.NewReader(expected),
+		"network_metrics_daemon_build_info", "network_metrics_daemon_pods_failed_permanently_total",
+		"network_metrics_daemon_status_parse_errors_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	if count := testutil.CollectAndCount(m.syncDuration); count != 2 {
+		t.Error("Expected sync durations for success and error, got", count)
+	}
+	if testutil.ToFloat64(m.lastResync) == 0 {
+		t.Error("Expected the last resync time to be set")
+	}
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	m := New("abcdef")
+	queue := workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(),
+		workqueue.RateLimitingQueueConfig{
+			Name:            "Pods",
+			MetricsProvider: m.WorkqueueMetricsProvider(),
+		})
+	defer queue.ShutDown()
+
+	queue.Add("namespace/pod1")
+	queue.Add("namespace/pod2")
+	item, _ := queue.Get()
+	queue.Done(item)
+	queue.AddRateLimited("namespace/pod3")
+
+	expected := `
+	# HELP network_metrics_daemon_workqueue_adds_total Total number of adds handled by the workqueue.
+	# TYPE network_metrics_daemon_workqueue_adds_total counter
+	network_metrics_daemon_workqueue_adds_total{name="Pods"} 2
+	# HELP network_metrics_daemon_workqueue_depth Current depth of the workqueue.
+	# TYPE network_metrics_daemon_workqueue_depth gauge
+	network_metrics_daemon_workqueue_depth{name="Pods"} 1
+	# HELP network_metrics_daemon_workqueue_retries_total Total number of retries handled by the workqueue.
+	# TYPE network_metrics_daemon_workqueue_retries_total counter
+	network_metrics_daemon_workqueue_retries_total{name="Pods"} 1
+	`
+	err := testutil.GatherAndCompare(m.registry, strings.NewReader(expected),
+		"network_metrics_daemon_workqueue_adds_total", "network_metrics_daemon_workqueue_depth", "network_metrics_daemon_workqueue_retries_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New("abcdef")
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/daemon-metrics", nil))
+	body := recorder.Body.String()
+	for _, metric := range []string{"network_metrics_daemon_build_info", "go_goroutines", "process_cpu_seconds_total"} {
+		if !strings.Contains(body, metric) {
+			t.Error("Expected", metric, "to be served")
+		}
+	}
+}
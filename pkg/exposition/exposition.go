@@ -0,0 +1,63 @@
+package exposition
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/klog"
+)
+
+// units are the metric name suffixes published as the unit of the metric
+// in the OpenMetrics format.
+var units = []string{"seconds", "bytes"}
+
+// Handler returns a handler serving the metrics collected by the given gatherer,
+// in the format negotiated via the Accept header: the Prometheus text format,
+// the OpenMetrics text format (with the units, the created timestamps of the
+// counters and the exemplars) or the protobuf delimited format.
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			klog.Errorf("Error gathering metrics: %v", err)
+			if len(families) == 0 {
+				http.Error(w, "Error gathering metrics", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format, expfmt.WithUnit(), expfmt.WithCreatedLines())
+		for _, family := range families {
+			setUnit(family)
+			if err := enc.Encode(family); err != nil {
+				klog.Errorf("Error encoding metric family %s: %v", family.GetName(), err)
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				klog.Errorf("Error closing the metrics encoder: %v", err)
+			}
+		}
+	})
+}
+
+// setUnit sets the unit of the given family according to the suffix of its name.
+func setUnit(family *dto.MetricFamily) {
+	name := family.GetName()
+	if family.GetType() == dto.MetricType_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(name, "_"+unit) {
+			u := unit
+			family.Unit = &u
+			return
+		}
+	}
+}
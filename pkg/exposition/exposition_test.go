@@ -0,0 +1,154 @@
+package exposition_test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/network-metrics-daemon/pkg/exposition"
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var timestamp = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// testCollector publishes a fixed set of metrics, covering the
+// features of the different formats.
+type testCollector struct{}
+
+func (testCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (testCollector) Collect(ch chan<- prometheus.Metric) {
+	info := prometheus.NewDesc("pod_network_name_info", "Metric to identify network names of networks added to pods.",
+		[]string{"pod", "namespace", "interface", "network_name"}, nil)
+	ch <- prometheus.MustNewConstMetric(info, prometheus.GaugeValue, 0, "podname", "namespacename", "net1", "namespace1/firstNAD")
+
+	received := prometheus.NewDesc("pod_network_receive_bytes_total", "Number of bytes received on the interface.",
+		[]string{"pod", "namespace", "interface"}, nil)
+	ch <- prometheus.MustNewConstMetric(received, prometheus.CounterValue, 1024, "podname", "namespacename", "net1")
+
+	parseErrors := prometheus.NewDesc("node_network_status_parse_errors_total",
+		"Number of failures parsing the network status annotation of the pods on the node.", nil, nil)
+	counter := prometheus.MustNewConstMetricWithCreatedTimestamp(parseErrors, prometheus.CounterValue, 2, timestamp)
+	ch <- prometheus.MustNewMetricWithExemplars(counter, prometheus.Exemplar{
+		Value:     1,
+		Labels:    prometheus.Labels{"pod_uid": "9f1c3f4e-6a8a-4b8e-9a53-6f6b0c1f2b7d"},
+		Timestamp: timestamp.Add(time.Minute),
+	})
+
+	lastResync := prometheus.NewDesc("network_metrics_daemon_informer_last_resync_timestamp_seconds",
+		"Time of the last resync of the pod informer, in seconds since the epoch.", nil, nil)
+	ch <- prometheus.MustNewConstMetric(lastResync, prometheus.GaugeValue, float64(timestamp.Unix()))
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name                string
+		accept              string
+		golden              string
+		expectedContentType string
+	}{
+		{
+			"text",
+			"",
+			"metrics.txt",
+			"text/plain; version=0.0.4; charset=utf-8; escaping=underscores",
+		},
+		{
+			"openmetrics",
+			"application/openmetrics-text; version=1.0.0",
+			"metrics.openmetrics.txt",
+			"application/openmetrics-text; version=1.0.0; charset=utf-8; escaping=underscores",
+		},
+		{
+			"protobuf",
+			"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited",
+			"metrics.pb",
+			"application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited; escaping=underscores",
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(testCollector{})
+	handler := exposition.Handler(registry)
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatal("Unexpected status", rec.Code)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != tc.expectedContentType {
+				t.Errorf("Expected content type %q, got %q", tc.expectedContentType, contentType)
+			}
+
+			compareGolden(t, rec.Body, tc.golden)
+		})
+	}
+}
+
+// TestPodMetrics checks the exposition of the metrics published for a pod.
+// Only the text format is checked, as the other ones carry the creation
+// time of the counters.
+func TestPodMetrics(t *testing.T) {
+	podMetrics := podmetrics.New()
+	podMetrics.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/sriov", DeviceInfo: &podnetwork.DeviceInfo{
+			Type: "pci",
+			Pci:  &podnetwork.PciDevice{PciAddress: "0000:3b:02.0"},
+		}},
+	})
+	podMetrics.UpdateMissingForPod("podname", "namespacename", "podname-uid", []podnetwork.RequestedNetwork{
+		{NetworkName: "namespace1/macvlan", Interface: "net2"},
+	})
+	podMetrics.StatusParseFailed("malformed-uid")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(podMetrics)
+	rec := httptest.NewRecorder()
+	exposition.Handler(registry).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code)
+	}
+	compareGolden(t, rec.Body, "podmetrics.txt")
+}
+
+// compareGolden compares the given body with the golden file with the given
+// name, updating it first when requested.
+func compareGolden(t *testing.T, r io.Reader, name string) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal("Failed to read body", err)
+	}
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, body, 0644); err != nil {
+			t.Fatal("Failed to update golden file", err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal("Failed to read golden file", err)
+	}
+	if !bytes.Equal(body, expected) {
+		t.Errorf("Output differs from %s, got:\n%s", golden, body)
+	}
+}
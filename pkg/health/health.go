@@ -0,0 +1,94 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Check returns an error if the related component is not healthy.
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker serves the result of a set of checks.
+type Checker struct {
+	mtx    sync.RWMutex
+	checks []namedCheck
+}
+
+// CheckResult is the result of a single check.
+type CheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+// Result is the result of all the checks, as served in verbose mode.
+type Result struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// NewChecker returns a checker with no checks, always healthy.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add adds a named check to the checker.
+func (c *Checker) Add(name string, check Check) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.checks = append(c.checks, namedCheck{name, check})
+}
+
+// Run runs all the checks.
+func (c *Checker) Run() Result {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	res := Result{Healthy: true, Checks: make([]CheckResult, 0, len(c.checks))}
+	for _, check := range c.checks {
+		checkRes := CheckResult{Name: check.name, Healthy: true}
+		if err := check.check(); err != nil {
+			checkRes.Healthy = false
+			checkRes.Message = err.Error()
+			res.Healthy = false
+		}
+		res.Checks = append(res.Checks, checkRes)
+	}
+	return res
+}
+
+// ServeHTTP serves the result of the checks, with status 200 if all of them
+// pass and 503 otherwise. With the verbose query parameter, the result of each
+// check is returned in JSON format.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res := c.Run()
+	status := http.StatusOK
+	if !res.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if res.Healthy {
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+		return
+	}
+	for _, check := range res.Checks {
+		if !check.Healthy {
+			fmt.Fprintf(w, "%s failed: %s\n", check.Name, check.Message)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/health"
+)
+
+func TestChecker(t *testing.T) {
+	tests := []struct {
+		name           string
+		checks         map[string]health.Check
+		url            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			"nochecks",
+			map[string]health.Check{},
+			"/readyz",
+			http.StatusOK,
+			"OK",
+		},
+		{
+			"passing",
+			map[string]health.Check{
+				"check": func() error { return nil },
+			},
+			"/readyz",
+			http.StatusOK,
+			"OK",
+		},
+		{
+			"failing",
+			map[string]health.Check{
+				"check": func() error { return errors.New("not synced") },
+			},
+			"/readyz",
+			http.StatusServiceUnavailable,
+			"check failed: not synced\n",
+		},
+		{
+			"verbose",
+			map[string]health.Check{
+				"check": func() error { return errors.New("not synced") },
+			},
+			"/readyz?verbose",
+			http.StatusServiceUnavailable,
+			`{"healthy":false,"checks":[{"name":"check","healthy":false,"message":"not synced"}]}` + "\n",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			checker := health.NewChecker()
+			for name, check := range tc.checks {
+				checker.Add(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if rec.Body.String() != tc.expectedBody {
+				t.Errorf("Expected body %q, got %q", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckerVerbose(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("first", func() error { return nil })
+	checker.Add("second", func() error { return errors.New("failed") })
+
+	rec := httptest.NewRecorder()
+	checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz?verbose", nil))
+
+	var res health.Result
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal("Failed to parse the result", err)
+	}
+	expected := []health.CheckResult{
+		{Name: "first", Healthy: true},
+		{Name: "second", Healthy: false, Message: "failed"},
+	}
+	if res.Healthy || len(res.Checks) != len(expected) {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+	for i := range expected {
+		if res.Checks[i] != expected[i] {
+			t.Errorf("Expected check %+v, got %+v", expected[i], res.Checks[i])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// recoveryWindow is how long without watch failures is needed to consider the
+// watch working again. It is larger than the maximum backoff of the reflector.
+const recoveryWindow = time.Minute
+
+// WatchMonitor keeps track of the failures of a watch connection.
+type WatchMonitor struct {
+	mtx          sync.Mutex
+	threshold    time.Duration
+	failingSince time.Time
+	lastFailure  time.Time
+	lastError    error
+	now          func() time.Time
+}
+
+// NewWatchMonitor returns a monitor considering the watch unhealthy when it
+// has been failing for longer than the given threshold.
+func NewWatchMonitor(threshold time.Duration) *WatchMonitor {
+	return &WatchMonitor{
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+// SetThreshold changes how long the watch can be failing before
+// being considered unhealthy.
+func (m *WatchMonitor) SetThreshold(threshold time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.threshold = threshold
+}
+
+// Failed records a failure of the watch.
+func (m *WatchMonitor) Failed(err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	now := m.now()
+	if m.failingSince.IsZero() || now.Sub(m.lastFailure) > recoveryWindow {
+		m.failingSince = now
+	}
+	m.lastFailure = now
+	m.lastError = err
+}
+
+// Succeeded records the watch is working, i.e. because an event was received.
+func (m *WatchMonitor) Succeeded() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.failingSince = time.Time{}
+}
+
+// Check returns an error if the watch has been failing for longer than the threshold.
+func (m *WatchMonitor) Check() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	now := m.now()
+	if m.failingSince.IsZero() || now.Sub(m.lastFailure) > recoveryWindow {
+		return nil
+	}
+	if failing := now.Sub(m.failingSince); failing > m.threshold {
+		return fmt.Errorf("watch failing for %s: %v", failing.Round(time.Second), m.lastError)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatchMonitor(t *testing.T) {
+	m := NewWatchMonitor(time.Minute)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if err := m.Check(); err != nil {
+		t.Error("Expected a new monitor to be healthy, got", err)
+	}
+
+	m.Failed(errors.New("connection refused"))
+	now = now.Add(30 * time.Second)
+	m.Failed(errors.New("connection refused"))
+	if err := m.Check(); err != nil {
+		t.Error("Expected the monitor to be healthy below the threshold, got", err)
+	}
+
+	now = now.Add(45 * time.Second)
+	m.Failed(errors.New("connection refused"))
+	if err := m.Check(); err == nil {
+		t.Error("Expected the monitor to be unhealthy above the threshold")
+	}
+
+	m.Succeeded()
+	if err := m.Check(); err != nil {
+		t.Error("Expected the monitor to be healthy after a success, got", err)
+	}
+
+	m.Failed(errors.New("connection refused"))
+	now = now.Add(2 * time.Minute)
+	if err := m.Check(); err != nil {
+		t.Error("Expected the monitor to be healthy with no recent failures, got", err)
+	}
+}
@@ -0,0 +1,106 @@
+package hostdevices
+
+import (
+	"strconv"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+const (
+	deviceInfo     = "node_network_secondary_device_info"
+	deviceInfoHelp = "Metric to identify the host devices the secondary networks of the pods may terminate on."
+	sriovVFs       = "node_network_sriov_vfs"
+	sriovVFsHelp   = "Number of virtual functions enabled on the physical function, by allocation to the pods on the node."
+)
+
+var (
+	deviceLabelNames = []string{"device", "pci_address", "driver", "pf", "vf_index", "numvfs", "master"}
+	vfsLabelNames    = []string{"pf", "state"}
+)
+
+// PublishesLabel returns true if the given label name is published by the
+// host device collectors.
+func PublishesLabel(name string) bool {
+	for _, names := range [][]string{deviceLabelNames, vfsLabelNames, vfStatsLabelNames} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AttachmentLister returns the networks of the pods backed by a PCI device.
+type AttachmentLister interface {
+	PCIAttachments() []podmetrics.PCIAttachment
+}
+
+// Collector publishes the inventory of the host network devices, and
+// the allocation of the virtual functions to the pods, read on each scrape.
+type Collector struct {
+	podmetrics.OptionalCollector
+	sysRoot     string
+	listLinks   func() ([]rtnl.Link, error)
+	attachments AttachmentLister
+}
+
+// NewCollector returns a new enabled collector, looking up the links returned
+// by listLinks in the sysfs mounted at sysRoot. The virtual functions found
+// among the given attachments are accounted as allocated.
+func NewCollector(sysRoot string, listLinks func() ([]rtnl.Link, error), attachments AttachmentLister) *Collector {
+	c := &Collector{
+		sysRoot:     sysRoot,
+		listLinks:   listLinks,
+		attachments: attachments,
+	}
+	c.SetEnabled(true)
+	return c
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if !c.Enabled() {
+		return
+	}
+	emitter := c.NewEmitter(ch)
+
+	links, err := c.listLinks()
+	if err != nil {
+		klog.Warningf("Failed to list the host links: %v", err)
+		return
+	}
+	allocated := make(map[string]bool)
+	for _, a := range c.attachments.PCIAttachments() {
+		allocated[a.PCIAddress] = true
+	}
+
+	// the devices are not related to a given pod, so they are not filtered
+	for _, d := range Scan(c.sysRoot, links) {
+		emitter.EmitUnfiltered(deviceInfo, deviceInfoHelp, prometheus.GaugeValue, 0,
+			deviceLabelNames, d.Name, d.PCIAddress, d.Driver, d.PF, optionalInt(d.VFIndex), optionalInt(d.NumVFs), d.Master)
+		if d.NumVFs < 0 {
+			continue
+		}
+		used := 0
+		for _, vf := range d.VFs {
+			if allocated[vf] {
+				used++
+			}
+		}
+		emitter.EmitUnfiltered(sriovVFs, sriovVFsHelp, prometheus.GaugeValue, float64(used), vfsLabelNames, d.Name, "allocated")
+		emitter.EmitUnfiltered(sriovVFs, sriovVFsHelp, prometheus.GaugeValue, float64(d.NumVFs-used), vfsLabelNames, d.Name, "free")
+	}
+}
+
+// optionalInt returns the given value as a label value,
+// or an empty string if negative.
+func optionalInt(v int) string {
+	if v < 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,62 @@
+package hostdevices_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/hostdevices"
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type staticAttachments []podmetrics.PCIAttachment
+
+func (s staticAttachments) PCIAttachments() []podmetrics.PCIAttachment {
+	return s
+}
+
+func listLinks() ([]rtnl.Link, error) {
+	return links, nil
+}
+
+func TestCollector(t *testing.T) {
+	attachments := staticAttachments{
+		{Pod: "podname", Namespace: "namespacename", Interface: "net1", NetworkName: "namespace1/sriov", PCIAddress: "0000:3b:02.1"},
+		{Pod: "podname", Namespace: "namespacename", Interface: "net2", NetworkName: "namespace1/other", PCIAddress: "0000:af:02.0"},
+	}
+	c := hostdevices.NewCollector(fakeSysfs(t), listLinks, attachments)
+
+	expected := `
+	# HELP node_network_secondary_device_info Metric to identify the host devices the secondary networks of the pods may terminate on.
+	# TYPE node_network_secondary_device_info gauge
+	node_network_secondary_device_info{device="bond0",driver="bond",master="",numvfs="",pci_address="",pf="",vf_index=""} 0
+	node_network_secondary_device_info{device="br-sec",driver="bridge",master="",numvfs="",pci_address="",pf="",vf_index=""} 0
+	node_network_secondary_device_info{device="ens1f0",driver="i40e",master="",numvfs="2",pci_address="0000:3b:00.0",pf="",vf_index=""} 0
+	node_network_secondary_device_info{device="ens1f0v0",driver="iavf",master="br-sec",numvfs="",pci_address="0000:3b:02.0",pf="ens1f0",vf_index="0"} 0
+	node_network_secondary_device_info{device="",driver="vfio-pci",master="",numvfs="",pci_address="0000:3b:02.1",pf="ens1f0",vf_index="1"} 0
+	node_network_secondary_device_info{device="ens1f1",driver="i40e",master="bond0",numvfs="",pci_address="0000:3b:00.1",pf="",vf_index=""} 0
+	# HELP node_network_sriov_vfs Number of virtual functions enabled on the physical function, by allocation to the pods on the node.
+	# TYPE node_network_sriov_vfs gauge
+	node_network_sriov_vfs{pf="ens1f0",state="allocated"} 1
+	node_network_sriov_vfs{pf="ens1f0",state="free"} 1
+	`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	c.SetEnabled(false)
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Error("Expected no metrics when disabled, got", count)
+	}
+}
+
+func TestCollectorLinkFailure(t *testing.T) {
+	c := hostdevices.NewCollector(fakeSysfs(t), func() ([]rtnl.Link, error) {
+		return nil, fmt.Errorf("netlink failure")
+	}, staticAttachments{})
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Error("Expected no metrics when the links can't be listed, got", count)
+	}
+}
@@ -0,0 +1,165 @@
+// Package hostdevices describes the host network devices the secondary
+// networks of the pods terminate on, such as the SR-IOV physical and virtual
+// functions, the bridges and the bonds.
+package hostdevices
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+)
+
+// pciAddress matches the address of a PCI device, i.e. 0000:3b:02.0
+var pciAddress = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+// virtualKinds are the kinds of the virtual links reported as devices, as
+// the secondary networks can be attached to them
+var virtualKinds = map[string]bool{
+	"bridge": true,
+	"bond":   true,
+}
+
+// Device is a host network device.
+type Device struct {
+	// Name is the name of the link, empty for the virtual
+	// functions with no link on the host, i.e. moved to a pod
+	Name       string
+	PCIAddress string
+	// Driver is the driver bound to the device, or the kind of the
+	// virtual links
+	Driver string
+	// PF is the name of the physical function of a virtual function
+	PF string
+	// VFIndex is the index of a virtual function, -1 otherwise
+	VFIndex int
+	// NumVFs is the number of virtual functions enabled on
+	// a physical function, -1 otherwise
+	NumVFs int
+	// VFs are the PCI addresses of the virtual functions of a
+	// physical function, in order of index
+	VFs []string
+	// Master is the name of the bridge or bond the link is enslaved to
+	Master string
+}
+
+// Scan returns the devices backing the given links, looking them up in the
+// sysfs mounted at sysRoot. The links backed by a device and the bridges
+// and bonds are reported, followed by the virtual functions of each
+// physical function, whether they have a link on the host or not.
+func Scan(sysRoot string, links []rtnl.Link) []Device {
+	names := make(map[int]string, len(links))
+	byName := make(map[string]rtnl.Link, len(links))
+	for _, l := range links {
+		names[l.Index] = l.Name
+		byName[l.Name] = l
+	}
+	sorted := append([]rtnl.Link(nil), links...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var res []Device
+	for _, l := range sorted {
+		devicePath := filepath.Join(sysRoot, "class", "net", l.Name, "device")
+		if _, err := os.Stat(devicePath); err != nil {
+			if virtualKinds[l.Kind] {
+				res = append(res, Device{Name: l.Name, Driver: l.Kind, VFIndex: -1, NumVFs: -1, Master: names[l.MasterIndex]})
+			}
+			continue
+		}
+		// the virtual functions are reported together with their physical function
+		if _, err := os.Stat(filepath.Join(devicePath, "physfn")); err == nil {
+			continue
+		}
+
+		pf := Device{
+			Name:       l.Name,
+			PCIAddress: pciAddressOf(devicePath),
+			Driver:     driverOf(devicePath),
+			VFIndex:    -1,
+			NumVFs:     -1,
+			Master:     names[l.MasterIndex],
+		}
+		numVFs, ok := readInt(filepath.Join(devicePath, "sriov_numvfs"))
+		if !ok {
+			res = append(res, pf)
+			continue
+		}
+		pf.NumVFs = numVFs
+
+		var vfs []Device
+		for i := 0; i < numVFs; i++ {
+			vfPath := filepath.Join(devicePath, "virtfn"+strconv.Itoa(i))
+			address := pciAddressOf(vfPath)
+			if address == "" {
+				continue
+			}
+			vf := Device{
+				Name:       netdevOf(vfPath),
+				PCIAddress: address,
+				Driver:     driverOf(vfPath),
+				PF:         l.Name,
+				VFIndex:    i,
+				NumVFs:     -1,
+			}
+			if link, ok := byName[vf.Name]; ok && vf.Name != "" {
+				vf.Master = names[link.MasterIndex]
+			}
+			pf.VFs = append(pf.VFs, address)
+			vfs = append(vfs, vf)
+		}
+		res = append(res, pf)
+		res = append(res, vfs...)
+	}
+	return res
+}
+
+// pciAddressOf returns the PCI address of the given sysfs device,
+// or an empty string if not a PCI device.
+func pciAddressOf(devicePath string) string {
+	target, err := os.Readlink(devicePath)
+	if err != nil {
+		return ""
+	}
+	if address := filepath.Base(target); pciAddress.MatchString(address) {
+		return address
+	}
+	return ""
+}
+
+// driverOf returns the name of the driver bound to the given
+// sysfs device, or an empty string if none.
+func driverOf(devicePath string) string {
+	target, err := os.Readlink(filepath.Join(devicePath, "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// netdevOf returns the name of the link of the given sysfs device, or
+// an empty string if it has none in the network namespace sysfs was
+// mounted from.
+func netdevOf(devicePath string) string {
+	entries, err := os.ReadDir(filepath.Join(devicePath, "net"))
+	if err != nil || len(entries) == 0 {
+		return ""
+	}
+	return entries[0].Name()
+}
+
+// readInt returns the integer found in the given file.
+func readInt(path string) (int, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	res, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, false
+	}
+	return res, true
+}
@@ -0,0 +1,95 @@
+package hostdevices_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/hostdevices"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+)
+
+// links are the host links found in the fake sysfs
+var links = []rtnl.Link{
+	{Index: 1, Name: "lo"},
+	{Index: 2, Name: "ens1f0"},
+	{Index: 3, Name: "ens1f1", MasterIndex: 6},
+	{Index: 4, Name: "ens1f0v0", MasterIndex: 5},
+	{Index: 5, Name: "br-sec", Kind: "bridge"},
+	{Index: 6, Name: "bond0", Kind: "bond"},
+	{Index: 7, Name: "veth1", Kind: "veth", MasterIndex: 5},
+}
+
+// fakeSysfs creates a sysfs tree with a physical function with two virtual
+// functions, one with a link on the host and one bound to vfio-pci, and
+// a physical function not supporting SR-IOV.
+func fakeSysfs(t *testing.T) string {
+	root := t.TempDir()
+	mkdir := func(path string) {
+		if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, path string) {
+		if err := os.Symlink(filepath.Join(root, target), filepath.Join(root, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, d := range []string{"i40e", "iavf", "vfio-pci"} {
+		mkdir("bus/pci/drivers/" + d)
+	}
+	devices := map[string]string{
+		"0000:3b:00.0": "i40e",
+		"0000:3b:00.1": "i40e",
+		"0000:3b:02.0": "iavf",
+		"0000:3b:02.1": "vfio-pci",
+	}
+	for address, driver := range devices {
+		mkdir("bus/pci/devices/" + address)
+		symlink("bus/pci/drivers/"+driver, "bus/pci/devices/"+address+"/driver")
+	}
+	write("bus/pci/devices/0000:3b:00.0/sriov_numvfs", "2\n")
+	for i, vf := range []string{"0000:3b:02.0", "0000:3b:02.1"} {
+		symlink("bus/pci/devices/"+vf, "bus/pci/devices/0000:3b:00.0/virtfn"+strconv.Itoa(i))
+		symlink("bus/pci/devices/0000:3b:00.0", "bus/pci/devices/"+vf+"/physfn")
+	}
+	mkdir("bus/pci/devices/0000:3b:02.0/net/ens1f0v0")
+
+	for name, address := range map[string]string{"ens1f0": "0000:3b:00.0", "ens1f1": "0000:3b:00.1", "ens1f0v0": "0000:3b:02.0"} {
+		mkdir("class/net/" + name)
+		symlink("bus/pci/devices/"+address, "class/net/"+name+"/device")
+	}
+	for _, name := range []string{"lo", "br-sec", "bond0", "veth1"} {
+		mkdir("class/net/" + name)
+	}
+	return root
+}
+
+func TestScan(t *testing.T) {
+	devices := hostdevices.Scan(fakeSysfs(t), links)
+	expected := []hostdevices.Device{
+		{Name: "bond0", Driver: "bond", VFIndex: -1, NumVFs: -1},
+		{Name: "br-sec", Driver: "bridge", VFIndex: -1, NumVFs: -1},
+		{Name: "ens1f0", PCIAddress: "0000:3b:00.0", Driver: "i40e", VFIndex: -1, NumVFs: 2, VFs: []string{"0000:3b:02.0", "0000:3b:02.1"}},
+		{Name: "ens1f0v0", PCIAddress: "0000:3b:02.0", Driver: "iavf", PF: "ens1f0", VFIndex: 0, NumVFs: -1, Master: "br-sec"},
+		{PCIAddress: "0000:3b:02.1", Driver: "vfio-pci", PF: "ens1f0", VFIndex: 1, NumVFs: -1},
+		{Name: "ens1f1", PCIAddress: "0000:3b:00.1", Driver: "i40e", VFIndex: -1, NumVFs: -1, Master: "bond0"},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Different result, expected\n%+v\ngot\n%+v", expected, devices)
+	}
+}
+
+func TestScanMissingSysfs(t *testing.T) {
+	if devices := hostdevices.Scan(t.TempDir(), links); len(devices) != 2 {
+		t.Error("Expected only the bridge and the bond, got", devices)
+	}
+}
@@ -0,0 +1,123 @@
+package hostdevices
+
+import (
+	"strconv"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+var vfStatsLabelNames = []string{"pod", "namespace", "interface", "network_name", "pf", "vf_index"}
+
+// vfCounter describes one of the published virtual function counters.
+type vfCounter struct {
+	name  string
+	help  string
+	value func(rtnl.VFStats) uint64
+}
+
+var vfCounters = []vfCounter{
+	{"pod_network_vf_receive_bytes_total",
+		"Cumulative count of bytes received by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.RxBytes }},
+	{"pod_network_vf_receive_packets_total",
+		"Cumulative count of packets received by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.RxPackets }},
+	{"pod_network_vf_receive_packets_dropped_total",
+		"Cumulative count of packets dropped while receiving on the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.RxDropped }},
+	{"pod_network_vf_receive_broadcast_packets_total",
+		"Cumulative count of broadcast packets received by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.Broadcast }},
+	{"pod_network_vf_receive_multicast_packets_total",
+		"Cumulative count of multicast packets received by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.Multicast }},
+	{"pod_network_vf_transmit_bytes_total",
+		"Cumulative count of bytes transmitted by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.TxBytes }},
+	{"pod_network_vf_transmit_packets_total",
+		"Cumulative count of packets transmitted by the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.TxPackets }},
+	{"pod_network_vf_transmit_packets_dropped_total",
+		"Cumulative count of packets dropped while transmitting on the virtual functions added to pods, as reported by the physical function.",
+		func(s rtnl.VFStats) uint64 { return s.TxDropped }},
+}
+
+// VFCollector publishes the counters of the virtual functions added to the
+// pods, as reported by their physical function. Unlike the counters read from
+// within the pods' network namespace, they are available also for the virtual
+// functions bound to a userspace driver, i.e. by DPDK applications.
+type VFCollector struct {
+	podmetrics.OptionalCollector
+	sysRoot     string
+	listLinks   func() ([]rtnl.Link, error)
+	attachments AttachmentLister
+}
+
+// NewVFCollector returns a new enabled collector, finding the physical function
+// and the index of the virtual functions of the given attachments in the sysfs
+// mounted at sysRoot. listLinks is expected to return the statistics of the
+// virtual functions together with the links.
+func NewVFCollector(sysRoot string, listLinks func() ([]rtnl.Link, error), attachments AttachmentLister) *VFCollector {
+	c := &VFCollector{
+		sysRoot:     sysRoot,
+		listLinks:   listLinks,
+		attachments: attachments,
+	}
+	c.SetEnabled(true)
+	return c
+}
+
+// Collect implements prometheus.Collector.
+func (c *VFCollector) Collect(ch chan<- prometheus.Metric) {
+	if !c.Enabled() {
+		return
+	}
+	emitter := c.NewEmitter(ch)
+
+	attachments := c.attachments.PCIAttachments()
+	if len(attachments) == 0 {
+		return
+	}
+	links, err := c.listLinks()
+	if err != nil {
+		klog.Warningf("Failed to list the host links: %v", err)
+		return
+	}
+
+	vfs := make(map[string]Device)
+	for _, d := range Scan(c.sysRoot, links) {
+		if d.PF != "" {
+			vfs[d.PCIAddress] = d
+		}
+	}
+	stats := make(map[string]map[int]rtnl.VFStats)
+	for _, l := range links {
+		for _, s := range l.VFs {
+			if !s.Reported {
+				continue
+			}
+			if stats[l.Name] == nil {
+				stats[l.Name] = make(map[int]rtnl.VFStats)
+			}
+			stats[l.Name][s.Index] = s
+		}
+	}
+
+	for _, a := range attachments {
+		vf, ok := vfs[a.PCIAddress]
+		if !ok {
+			continue
+		}
+		s, ok := stats[vf.PF][vf.VFIndex]
+		if !ok {
+			continue
+		}
+		for _, counter := range vfCounters {
+			emitter.Emit(counter.name, counter.help, prometheus.CounterValue, float64(counter.value(s)),
+				vfStatsLabelNames, a.Pod, a.Namespace, a.Interface, a.NetworkName, vf.PF, strconv.Itoa(vf.VFIndex))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package hostdevices_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/hostdevices"
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVFCollector(t *testing.T) {
+	withVFs := append([]rtnl.Link(nil), links...)
+	withVFs[1].VFs = []rtnl.VFStats{
+		// the counters of the first virtual function are not reported
+		{Index: 0},
+		{Index: 1, Reported: true, RxPackets: 10, TxPackets: 20, RxBytes: 1000, TxBytes: 2000, Broadcast: 1, Multicast: 2, RxDropped: 3, TxDropped: 4},
+	}
+	attachments := staticAttachments{
+		{Pod: "podname1", Namespace: "namespacename", Interface: "net1", NetworkName: "namespace1/sriov", PCIAddress: "0000:3b:02.0"},
+		{Pod: "podname2", Namespace: "namespacename", NetworkName: "namespace1/sriov-dpdk", PCIAddress: "0000:3b:02.1"},
+		{Pod: "podname3", Namespace: "namespacename", Interface: "net1", NetworkName: "namespace1/other", PCIAddress: "0000:af:02.0"},
+	}
+	c := hostdevices.NewVFCollector(fakeSysfs(t), func() ([]rtnl.Link, error) {
+		return withVFs, nil
+	}, attachments)
+
+	expected := `
+	# HELP pod_network_vf_receive_bytes_total Cumulative count of bytes received by the virtual functions added to pods, as reported by the physical function.
+	# TYPE pod_network_vf_receive_bytes_total counter
+	pod_network_vf_receive_bytes_total{interface="",namespace="namespacename",network_name="namespace1/sriov-dpdk",pf="ens1f0",pod="podname2",vf_index="1"} 1000
+	# HELP pod_network_vf_receive_broadcast_packets_total Cumulative count of broadcast packets received by the virtual functions added to pods, as reported by the physical function.
+	# TYPE pod_network_vf_receive_broadcast_packets_total counter
+	pod_network_vf_receive_broadcast_packets_total{interface="",namespace="namespacename",network_name="namespace1/sriov-dpdk",pf="ens1f0",pod="podname2",vf_index="1"} 1
+	# HELP pod_network_vf_transmit_packets_dropped_total Cumulative count of packets dropped while transmitting on the virtual functions added to pods, as reported by the physical function.
+	# TYPE pod_network_vf_transmit_packets_dropped_total counter
+	pod_network_vf_transmit_packets_dropped_total{interface="",namespace="namespacename",network_name="namespace1/sriov-dpdk",pf="ens1f0",pod="podname2",vf_index="1"} 4
+	`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"pod_network_vf_receive_bytes_total", "pod_network_vf_receive_broadcast_packets_total", "pod_network_vf_transmit_packets_dropped_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+	if count := testutil.CollectAndCount(c); count != 8 {
+		t.Error("Expected 8 counters, got", count)
+	}
+
+	c.SetLabelOptions(podmetrics.LabelOptions{
+		Deny: []podmetrics.FilterRule{{Namespaces: []string{"namespacename"}}},
+	})
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Error("Expected the counters to be filtered, got", count)
+	}
+}
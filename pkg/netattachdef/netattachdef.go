@@ -0,0 +1,101 @@
+package netattachdef
+
+import (
+	"encoding/json"
+	"strings"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions/k8s.cni.cncf.io/v1"
+	nadlisters "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ResourceName is the annotation of the network attachment definitions
+// carrying the name of the device plugin resource backing the network
+const ResourceName = "k8s.v1.cni.cncf.io/resourceName"
+
+// Metadata describes the network attachment definition a pod network
+// refers to.
+type Metadata struct {
+	// PluginType is the type of the CNI plugin configured by the definition,
+	// the first one in case of a plugin list
+	PluginType string
+	// ResourceName is the device plugin resource backing the network
+	ResourceName string
+	// Labels are the labels of the definition
+	Labels map[string]string
+}
+
+// cniConfig contains the fields of a CNI configuration, or configuration
+// list, relevant to find the plugin type.
+type cniConfig struct {
+	Type    string `json:"type,omitempty"`
+	Plugins []struct {
+		Type string `json:"type,omitempty"`
+	} `json:"plugins,omitempty"`
+}
+
+// Resolver finds the network attachment definitions the pod networks refer
+// to, from the cache of an informer. As the definitions are looked up each
+// time, the changes to them are reflected as soon as the informer sees them.
+type Resolver struct {
+	lister nadlisters.NetworkAttachmentDefinitionLister
+	synced cache.InformerSynced
+}
+
+// NewResolver returns a resolver backed by the given informer.
+func NewResolver(informer nadinformers.NetworkAttachmentDefinitionInformer) *Resolver {
+	return &Resolver{
+		lister: informer.Lister(),
+		synced: informer.Informer().HasSynced,
+	}
+}
+
+// HasSynced returns true once the informer cache is synced.
+func (r *Resolver) HasSynced() bool {
+	return r.synced()
+}
+
+// Resolve returns the metadata of the definition the given network of a pod
+// in the given namespace refers to. The network name is either the namespaced
+// name of the definition, or its name when in the same namespace as the pod.
+func (r *Resolver) Resolve(podNamespace, networkName string) (Metadata, bool) {
+	namespace, name := podNamespace, networkName
+	if i := strings.Index(networkName, "/"); i >= 0 {
+		namespace, name = networkName[:i], networkName[i+1:]
+	}
+	nad, err := r.lister.NetworkAttachmentDefinitions(namespace).Get(name)
+	if err != nil {
+		return Metadata{}, false
+	}
+	return MetadataOf(nad), true
+}
+
+// MetadataOf returns the metadata of the given definition.
+func MetadataOf(nad *nadv1.NetworkAttachmentDefinition) Metadata {
+	return Metadata{
+		PluginType:   pluginType(nad.Spec.Config),
+		ResourceName: nad.Annotations[ResourceName],
+		Labels:       nad.Labels,
+	}
+}
+
+// pluginType returns the type of the plugin of the given CNI configuration,
+// or an empty string if not found. The configuration is empty when it is
+// provided by a file on the nodes.
+func pluginType(config string) string {
+	if config == "" {
+		return ""
+	}
+	var c cniConfig
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return ""
+	}
+	if c.Type != "" {
+		return c.Type
+	}
+	if len(c.Plugins) > 0 {
+		return c.Plugins[0].Type
+	}
+	return ""
+}
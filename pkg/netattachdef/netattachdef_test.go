@@ -0,0 +1,113 @@
+package netattachdef_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/openshift/network-metrics-daemon/pkg/netattachdef"
+)
+
+func newNAD(namespace, name, config string, annotations, labels map[string]string) *nadv1.NetworkAttachmentDefinition {
+	return &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+			Labels:      labels,
+		},
+		Spec: nadv1.NetworkAttachmentDefinitionSpec{Config: config},
+	}
+}
+
+var metadataTests = []struct {
+	testName string
+	nad      *nadv1.NetworkAttachmentDefinition
+	res      netattachdef.Metadata
+}{
+	{"plugin",
+		newNAD("ns1", "macvlan-net", `{"cniVersion": "0.3.1", "type": "macvlan", "master": "eth1"}`, nil, nil),
+		netattachdef.Metadata{PluginType: "macvlan"},
+	},
+	{"pluginlist",
+		newNAD("ns1", "bridge-net", `{"cniVersion": "0.3.1", "plugins": [{"type": "bridge"}, {"type": "tuning"}]}`, nil, nil),
+		netattachdef.Metadata{PluginType: "bridge"},
+	},
+	{"resourcename",
+		newNAD("ns1", "sriov-net", `{"type": "sriov"}`,
+			map[string]string{netattachdef.ResourceName: "openshift.io/intelnics"},
+			map[string]string{"team": "red"}),
+		netattachdef.Metadata{PluginType: "sriov", ResourceName: "openshift.io/intelnics", Labels: map[string]string{"team": "red"}},
+	},
+	{"configfromfile",
+		newNAD("ns1", "from-file", "", nil, nil),
+		netattachdef.Metadata{},
+	},
+	{"malformedconfig",
+		newNAD("ns1", "malformed", `{"type": `, nil, nil),
+		netattachdef.Metadata{},
+	},
+}
+
+func TestMetadataOf(t *testing.T) {
+	for _, tst := range metadataTests {
+		if res := netattachdef.MetadataOf(tst.nad); !reflect.DeepEqual(res, tst.res) {
+			t.Error(tst.testName, "Different result, expected", tst.res, "got", res)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	// the definitions are created through the client, as the tracker of the fake
+	// clientset guesses a resource name different from the plural of the CRD
+	client := nadfake.NewSimpleClientset()
+	for _, nad := range []*nadv1.NetworkAttachmentDefinition{
+		newNAD("ns1", "macvlan-net", `{"type": "macvlan"}`, nil, map[string]string{"team": "red"}),
+		newNAD("ns2", "sriov-net", `{"type": "sriov"}`, map[string]string{netattachdef.ResourceName: "openshift.io/intelnics"}, nil),
+	} {
+		_, err := client.K8sCniCncfIoV1().NetworkAttachmentDefinitions(nad.Namespace).Create(context.Background(), nad, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal("Failed to create the definition", err)
+		}
+	}
+	factory := nadinformers.NewSharedInformerFactory(client, 0)
+	resolver := netattachdef.NewResolver(factory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, resolver.HasSynced) {
+		t.Fatal("Failed to sync the informer")
+	}
+
+	if res, ok := resolver.Resolve("ns1", "macvlan-net"); !ok || res.PluginType != "macvlan" {
+		t.Error("Expected the definition in the pod namespace to be found, got", res, ok)
+	}
+	if res, ok := resolver.Resolve("ns1", "ns2/sriov-net"); !ok || res.ResourceName != "openshift.io/intelnics" {
+		t.Error("Expected the namespaced definition to be found, got", res, ok)
+	}
+	if res, ok := resolver.Resolve("ns1", "sriov-net"); ok {
+		t.Error("Expected the definition in another namespace not to be found, got", res)
+	}
+
+	updated := newNAD("ns1", "macvlan-net", `{"type": "ipvlan"}`, nil, map[string]string{"team": "blue"})
+	_, err := client.K8sCniCncfIoV1().NetworkAttachmentDefinitions("ns1").Update(context.Background(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal("Failed to update the definition", err)
+	}
+	expected := netattachdef.Metadata{PluginType: "ipvlan", Labels: map[string]string{"team": "blue"}}
+	for i := 0; i < 100; i++ {
+		if res, _ := resolver.Resolve("ns1", "macvlan-net"); reflect.DeepEqual(res, expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected the update to be resolved")
+}
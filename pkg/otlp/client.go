@@ -0,0 +1,131 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog"
+)
+
+// grpcClient sends the export requests to the grpc metrics service.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	service collectorpb.MetricsServiceClient
+}
+
+// newGRPCClient returns a client connecting to the given endpoint, with
+// TLS when its scheme is https.
+func newGRPCClient(endpoint string) (*grpcClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp endpoint %s: %v", endpoint, err)
+	}
+	var creds credentials.TransportCredentials
+	switch u.Scheme {
+	case "http":
+		creds = insecure.NewCredentials()
+	case "https":
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	default:
+		return nil, fmt.Errorf("invalid otlp endpoint %s, the scheme must be http or https", endpoint)
+	}
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{conn: conn, service: collectorpb.NewMetricsServiceClient(conn)}, nil
+}
+
+func (c *grpcClient) export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (bool, error) {
+	res, err := c.service.Export(ctx, req)
+	if err != nil {
+		// the codes retryable according to the OTLP specification
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange,
+			codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+			return true, err
+		}
+		return false, err
+	}
+	logPartialSuccess(res)
+	return false, nil
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
+
+// httpClient posts the export requests in their binary protobuf encoding.
+type httpClient struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPClient(endpoint string) (*httpClient, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid otlp endpoint %s: %v", endpoint, err)
+	}
+	return &httpClient{url: endpoint, client: &http.Client{}}, nil
+}
+
+func (c *httpClient) export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (bool, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "network-metrics-daemon")
+	res, err := c.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return false, nil
+		}
+		var exportRes collectorpb.ExportMetricsServiceResponse
+		if err := proto.Unmarshal(data, &exportRes); err == nil {
+			logPartialSuccess(&exportRes)
+		}
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	err = fmt.Errorf("server returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	// the statuses retryable according to the OTLP specification
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, err
+	}
+	return false, err
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// logPartialSuccess logs the data points rejected by the collector, if any.
+func logPartialSuccess(res *collectorpb.ExportMetricsServiceResponse) {
+	if partial := res.GetPartialSuccess(); partial.GetRejectedDataPoints() > 0 || partial.GetErrorMessage() != "" {
+		klog.Warningf("The otlp collector rejected %d data points: %s", partial.GetRejectedDataPoints(), partial.GetErrorMessage())
+	}
+}
@@ -0,0 +1,355 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"k8s.io/klog"
+)
+
+// The protocols the metrics can be exported with.
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+const (
+	serviceName = "network-metrics-daemon"
+	scopeName   = "github.com/openshift/network-metrics-daemon"
+)
+
+// convention describes how a Prometheus metric family is exported.
+type convention struct {
+	name        string
+	description string
+	unit        string
+	attributes  []attribute.KeyValue
+}
+
+// conventions maps the interface counters to the metrics defined by the
+// OpenTelemetry semantic conventions. The metrics not defined there are
+// exported with their Prometheus name.
+var conventions = map[string]convention{
+	"pod_network_receive_bytes_total":   {"k8s.pod.network.io", "Network bytes for the Pod.", "By", []attribute.KeyValue{semconv.NetworkIODirectionReceive}},
+	"pod_network_transmit_bytes_total":  {"k8s.pod.network.io", "Network bytes for the Pod.", "By", []attribute.KeyValue{semconv.NetworkIODirectionTransmit}},
+	"pod_network_receive_errors_total":  {"k8s.pod.network.errors", "Pod network errors.", "{error}", []attribute.KeyValue{semconv.NetworkIODirectionReceive}},
+	"pod_network_transmit_errors_total": {"k8s.pod.network.errors", "Pod network errors.", "{error}", []attribute.KeyValue{semconv.NetworkIODirectionTransmit}},
+}
+
+// labelAttributes maps the Prometheus labels to the attributes of the
+// semantic conventions. The other labels are exported as they are.
+var labelAttributes = map[string]attribute.Key{
+	"interface": semconv.NetworkInterfaceNameKey,
+	"ip":        semconv.NetworkLocalAddressKey,
+	"family":    semconv.NetworkTypeKey,
+}
+
+// Options controls how the metrics are exported.
+type Options struct {
+	// Endpoint is the url of the collector, i.e. http://collector:4317 with
+	// the grpc protocol, or http://collector:4318/v1/metrics with the http one
+	Endpoint string
+	// Protocol is either grpc or http/protobuf
+	Protocol string
+	// Interval is how often the metrics are exported
+	Interval time.Duration
+	// Timeout is the timeout of each export, including the retries
+	Timeout time.Duration
+	// NodeName is added as k8s.node.name to all the resources
+	NodeName string
+}
+
+// DefaultOptions returns the default options, with no endpoint.
+func DefaultOptions() Options {
+	return Options{
+		Protocol: ProtocolGRPC,
+		Interval: 30 * time.Second,
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Exporter periodically exports the metrics collected by a gatherer to an
+// OpenTelemetry collector. The pod metrics are exported as one resource per
+// pod, identified by its name and namespace, and the node metrics as the
+// resource of the node, all of them in a single request.
+type Exporter struct {
+	options  Options
+	gatherer prometheus.Gatherer
+	client   client
+	start    time.Time
+}
+
+// client sends the export requests to the collector.
+type client interface {
+	// export sends the given request, returning whether
+	// it can be retried in case of failure
+	export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (bool, error)
+	close() error
+}
+
+// minBackoff is the time waited before the first retry of a failed
+// export, doubled at each retry
+const minBackoff = 500 * time.Millisecond
+
+// New returns an exporter exporting the metrics collected by the given gatherer.
+func New(ctx context.Context, options Options, gatherer prometheus.Gatherer) (*Exporter, error) {
+	if options.Endpoint == "" {
+		return nil, fmt.Errorf("otlp endpoint not set")
+	}
+	if options.Interval <= 0 {
+		return nil, fmt.Errorf("invalid otlp export interval %s", options.Interval)
+	}
+
+	var c client
+	var err error
+	switch options.Protocol {
+	case ProtocolGRPC:
+		c, err = newGRPCClient(options.Endpoint)
+	case ProtocolHTTP:
+		c, err = newHTTPClient(options.Endpoint)
+	default:
+		return nil, fmt.Errorf("invalid otlp protocol %s", options.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %v", err)
+	}
+
+	return &Exporter{
+		options:  options,
+		gatherer: gatherer,
+		client:   c,
+		start:    time.Now(),
+	}, nil
+}
+
+// Run exports the metrics until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		e.export(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return e.client.close()
+		case <-ticker.C:
+		}
+	}
+}
+
+// export exports the current value of the metrics, retrying until
+// the export timeout expires.
+func (e *Exporter) export(ctx context.Context, now time.Time) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		klog.Warningf("Error gathering metrics for otlp: %v", err)
+	}
+	req := &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: toResourceMetrics(families, e.options.NodeName, e.start, now),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.options.Timeout)
+	defer cancel()
+	backoff := minBackoff
+	for {
+		retry, err := e.client.export(ctx, req)
+		if err == nil {
+			return
+		}
+		if !retry {
+			klog.Errorf("Dropping the otlp metrics rejected by the collector: %v", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			klog.Warningf("Failed to export otlp metrics: %v", err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// resourceKey identifies the resource a series belongs to. It is empty
+// for the node resource.
+type resourceKey struct {
+	pod       string
+	namespace string
+}
+
+// resourceBuilder accumulates the metrics of a resource.
+type resourceBuilder struct {
+	// uid is the uid of the pod, if carried by one of its series
+	uid     string
+	metrics map[string]*metricBuilder
+}
+
+// metricBuilder accumulates the data points of a metric.
+type metricBuilder struct {
+	description string
+	unit        string
+	sum         bool
+	points      []*metricspb.NumberDataPoint
+}
+
+// toResourceMetrics converts the given metric families to one ResourceMetrics per
+// pod, plus one for the node. Only counters, gauges and untyped metrics are converted.
+func toResourceMetrics(families []*dto.MetricFamily, nodeName string, start, now time.Time) []*metricspb.ResourceMetrics {
+	resources := make(map[resourceKey]*resourceBuilder)
+	for _, family := range families {
+		conv, ok := conventions[family.GetName()]
+		if !ok {
+			conv = convention{name: family.GetName(), description: family.GetHelp()}
+		}
+
+		for _, m := range family.GetMetric() {
+			var value float64
+			sum := false
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				value, sum = m.GetCounter().GetValue(), true
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			var key resourceKey
+			var uid string
+			attributes := append([]attribute.KeyValue{}, conv.attributes...)
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "pod":
+					key.pod = l.GetValue()
+				case "namespace":
+					key.namespace = l.GetValue()
+				case "uid":
+					uid = l.GetValue()
+				default:
+					name, ok := labelAttributes[l.GetName()]
+					if !ok {
+						name = attribute.Key(l.GetName())
+					}
+					attributes = append(attributes, name.String(l.GetValue()))
+				}
+			}
+
+			resource, ok := resources[key]
+			if !ok {
+				resource = &resourceBuilder{metrics: make(map[string]*metricBuilder)}
+				resources[key] = resource
+			}
+			if uid != "" {
+				resource.uid = uid
+			}
+			builder, ok := resource.metrics[conv.name]
+			if !ok {
+				builder = &metricBuilder{description: conv.description, unit: conv.unit, sum: sum}
+				resource.metrics[conv.name] = builder
+			}
+			point := &metricspb.NumberDataPoint{
+				Attributes:   keyValues(attributes...),
+				TimeUnixNano: uint64(now.UnixNano()),
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			}
+			if sum {
+				startTime := start
+				if created := m.GetCounter().GetCreatedTimestamp(); created != nil {
+					startTime = created.AsTime()
+				}
+				point.StartTimeUnixNano = uint64(startTime.UnixNano())
+			}
+			builder.points = append(builder.points, point)
+		}
+	}
+
+	keys := make([]resourceKey, 0, len(resources))
+	for k := range resources {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].pod < keys[j].pod
+	})
+
+	res := make([]*metricspb.ResourceMetrics, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, &metricspb.ResourceMetrics{
+			Resource: newResource(k, resources[k].uid, nodeName),
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: buildMetrics(resources[k].metrics),
+			}},
+			SchemaUrl: semconv.SchemaURL,
+		})
+	}
+	return res
+}
+
+func newResource(key resourceKey, uid, nodeName string) *resourcepb.Resource {
+	attributes := []attribute.KeyValue{semconv.ServiceName(serviceName)}
+	if nodeName != "" {
+		attributes = append(attributes, semconv.K8SNodeName(nodeName))
+	}
+	if key.pod != "" {
+		attributes = append(attributes, semconv.K8SPodName(key.pod))
+	}
+	if key.namespace != "" {
+		attributes = append(attributes, semconv.K8SNamespaceName(key.namespace))
+	}
+	if uid != "" {
+		attributes = append(attributes, semconv.K8SPodUID(uid))
+	}
+	return &resourcepb.Resource{Attributes: keyValues(attributes...)}
+}
+
+// keyValues converts the given string attributes, sorted by key.
+func keyValues(attributes ...attribute.KeyValue) []*commonpb.KeyValue {
+	res := make([]*commonpb.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		res = append(res, &commonpb.KeyValue{
+			Key:   string(a.Key),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: a.Value.AsString()}},
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+func buildMetrics(builders map[string]*metricBuilder) []*metricspb.Metric {
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*metricspb.Metric, 0, len(names))
+	for _, name := range names {
+		b := builders[name]
+		m := &metricspb.Metric{Name: name, Description: b.description, Unit: b.unit}
+		if b.sum {
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             b.points,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}}
+		} else {
+			m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: b.points}}
+		}
+		res = append(res, m)
+	}
+	return res
+}
@@ -0,0 +1,268 @@
+package otlp_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openshift/network-metrics-daemon/pkg/otlp"
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+)
+
+// receiver records the resource metrics received by the grpc and http servers.
+type receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	mtx       sync.Mutex
+	requests  int
+	resources []*metricspb.ResourceMetrics
+}
+
+func (r *receiver) Export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.requests++
+	r.resources = append(r.resources, req.GetResourceMetrics()...)
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var exportReq collectorpb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &exportReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, _ := r.Export(req.Context(), &exportReq)
+	data, _ := proto.Marshal(res)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+func (r *receiver) received() (int, []*metricspb.ResourceMetrics) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.requests, append([]*metricspb.ResourceMetrics{}, r.resources...)
+}
+
+func startGRPC(t *testing.T, r *receiver) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen", err)
+	}
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, r)
+	go server.Serve(listener)
+	return "http://" + listener.Addr().String(), server.Stop
+}
+
+func startHTTP(t *testing.T, r *receiver) (string, func()) {
+	server := httptest.NewServer(r)
+	return server.URL + "/v1/metrics", server.Close
+}
+
+func newGatherer() prometheus.Gatherer {
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.UIDLabel = true
+	p.SetOptions(options)
+	p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	received := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_network_receive_bytes_total",
+		Help: "Number of bytes received on the interface.",
+	}, []string{"pod", "namespace", "interface", "network_name"})
+	received.WithLabelValues("podname", "namespacename", "net1", "namespace1/firstNAD").Add(1024)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(p, received)
+	return registry
+}
+
+// attributesString returns the given attributes as sorted key=value pairs.
+func attributesString(attributes []*commonpb.KeyValue) string {
+	res := make([]string, 0, len(attributes))
+	for _, a := range attributes {
+		res = append(res, a.GetKey()+"="+a.GetValue().GetStringValue())
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+// dataPoints returns the data points of all the received metrics, indexed by resource
+// attributes, metric name, and data point attributes.
+func dataPoints(resources []*metricspb.ResourceMetrics) map[string]float64 {
+	res := make(map[string]float64)
+	for _, rm := range resources {
+		resource := attributesString(rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				points := m.GetGauge().GetDataPoints()
+				if sum := m.GetSum(); sum != nil {
+					points = sum.GetDataPoints()
+				}
+				for _, p := range points {
+					res[resource+" "+m.GetName()+" "+attributesString(p.GetAttributes())] = p.GetAsDouble()
+				}
+			}
+		}
+	}
+	return res
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		protocol string
+		start    func(t *testing.T, r *receiver) (string, func())
+	}{
+		{otlp.ProtocolGRPC, startGRPC},
+		{otlp.ProtocolHTTP, startHTTP},
+	}
+
+	podResource := "k8s.namespace.name=namespacename,k8s.node.name=node1,k8s.pod.name=podname,k8s.pod.uid=podname-uid,service.name=network-metrics-daemon"
+	nodeResource := "k8s.node.name=node1,service.name=network-metrics-daemon"
+	expected := map[string]float64{
+		podResource + " pod_network_name_info network.interface.name=net1,network_name=namespace1/firstNAD":                           0,
+		podResource + " k8s.pod.network.io network.interface.name=net1,network.io.direction=receive,network_name=namespace1/firstNAD": 1024,
+		nodeResource + " node_network_attachments network_name=namespace1/firstNAD":                                                   1,
+		nodeResource + " node_pods_with_secondary_networks ":                                                                          1,
+		nodeResource + " node_network_status_parse_errors_total ":                                                                     0,
+		nodeResource + " node_network_attachment_mismatches_total ":                                                                   0,
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.protocol, func(t *testing.T) {
+			t.Parallel()
+			r := &receiver{}
+			endpoint, stop := tc.start(t, r)
+			defer stop()
+
+			options := otlp.DefaultOptions()
+			options.Endpoint = endpoint
+			options.Protocol = tc.protocol
+			options.Interval = time.Hour
+			options.NodeName = "node1"
+			exporter, err := otlp.New(context.Background(), options, newGatherer())
+			if err != nil {
+				t.Fatal("Failed to create exporter", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- exporter.Run(ctx)
+			}()
+			for i := 0; i < 200; i++ {
+				if requests, _ := r.received(); requests > 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Error("Unexpected error stopping the exporter", err)
+			}
+
+			// the pod and the node resources are exported together
+			requests, resources := r.received()
+			if requests != 1 || len(resources) != 2 {
+				t.Errorf("Expected one request with 2 resources, got %d requests with %d resources", requests, len(resources))
+			}
+			points := dataPoints(resources)
+			for k, v := range expected {
+				value, ok := points[k]
+				if !ok {
+					t.Errorf("Expected data point %q, got %v", k, points)
+					continue
+				}
+				if value != v {
+					t.Errorf("Expected %q to be %v, got %v", k, v, value)
+				}
+			}
+			if len(points) != len(expected) {
+				t.Errorf("Expected %d data points, got %v", len(expected), points)
+			}
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	options := otlp.DefaultOptions()
+	if _, err := otlp.New(context.Background(), options, newGatherer()); err == nil {
+		t.Error("Expected an error with no endpoint")
+	}
+	options.Endpoint = "http://localhost:4317"
+	options.Protocol = "invalid"
+	if _, err := otlp.New(context.Background(), options, newGatherer()); err == nil {
+		t.Error("Expected an error with an invalid protocol")
+	}
+	options.Endpoint = "localhost:4317"
+	options.Protocol = otlp.ProtocolGRPC
+	if _, err := otlp.New(context.Background(), options, newGatherer()); err == nil {
+		t.Error("Expected an error with an endpoint with no scheme")
+	}
+}
+
+func TestExportRetries(t *testing.T) {
+	r := &receiver{}
+	failures := 2
+	var mtx sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	options := otlp.DefaultOptions()
+	options.Endpoint = server.URL + "/v1/metrics"
+	options.Protocol = otlp.ProtocolHTTP
+	options.Interval = time.Hour
+	exporter, err := otlp.New(context.Background(), options, newGatherer())
+	if err != nil {
+		t.Fatal("Failed to create exporter", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- exporter.Run(ctx)
+	}()
+	for i := 0; i < 500; i++ {
+		if requests, _ := r.received(); requests > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if requests, _ := r.received(); requests != 1 {
+		t.Error("Expected the export to succeed after the retries, got", requests)
+	}
+}
@@ -0,0 +1,51 @@
+package podmetrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OptionalCollector is meant to be embedded by the collectors built on the
+// Emitter that can be enabled at runtime. As the labels of their metrics
+// depend on the label options that can change at runtime too, they are
+// unchecked collectors and no descriptor is sent.
+type OptionalCollector struct {
+	mtx     sync.Mutex
+	enabled bool
+	labels  LabelOptions
+}
+
+// SetEnabled enables or disables the collection.
+func (c *OptionalCollector) SetEnabled(enabled bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.enabled = enabled
+}
+
+// Enabled returns true if the collection is enabled.
+func (c *OptionalCollector) Enabled() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.enabled
+}
+
+// SetLabelOptions changes the options deciding which series are published
+// and their labels.
+func (c *OptionalCollector) SetLabelOptions(labels LabelOptions) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.labels = labels
+}
+
+// NewEmitter returns an emitter sending to the given channel according
+// to the current label options.
+func (c *OptionalCollector) NewEmitter(ch chan<- prometheus.Metric) *Emitter {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return NewEmitter(ch, c.labels)
+}
+
+// Describe implements prometheus.Collector.
+func (c *OptionalCollector) Describe(ch chan<- *prometheus.Desc) {
+}
@@ -0,0 +1,318 @@
+package podmetrics
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitizePodLabel returns the metric label name for the given pod label.
+func sanitizePodLabel(name string) string {
+	return "label_" + invalidLabelChars.ReplaceAllString(name, "_")
+}
+
+// sanitizeNADLabel returns the metric label name for the given
+// network attachment definition label.
+func sanitizeNADLabel(name string) string {
+	return "nad_" + sanitizePodLabel(name)
+}
+
+// PublishesLabel returns true if the given label name is published by the
+// pod metrics, the ones of the pod and network attachment definition labels
+// included.
+func PublishesLabel(name string) bool {
+	if strings.HasPrefix(name, "label_") || strings.HasPrefix(name, "nad_label_") {
+		return true
+	}
+	for _, names := range [][]string{netAttachDefLabels, netAttachDefMetadataLabels, ipLabels, attachmentMissingLabels, deviceLabelNames, {"uid"}} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedLabels returns the given labels found in the allowlist, keyed by
+// their sanitized name. "*" allows all the labels. When different labels are
+// sanitized to the same name, the first one in alphabetical order is kept.
+func allowedLabels(labels map[string]string, allowlist []string, sanitize func(string) string) map[string]string {
+	res := make(map[string]string)
+	if len(allowlist) == 0 {
+		return res
+	}
+	allowAll := false
+	allowed := make(map[string]bool, len(allowlist))
+	for _, l := range allowlist {
+		if l == "*" {
+			allowAll = true
+		}
+		allowed[l] = true
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !allowAll && !allowed[name] {
+			continue
+		}
+		sanitized := sanitize(name)
+		if _, ok := res[sanitized]; ok {
+			continue
+		}
+		res[sanitized] = labels[name]
+	}
+	return res
+}
+
+func sortedKeys(m map[string]bool) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// LabelOptions decides which series are published and how they are labeled.
+type LabelOptions struct {
+	// ConstLabels are added to all the published metrics
+	ConstLabels map[string]string
+	// Allow, when not empty, restricts the published series to the ones
+	// matching at least one rule
+	Allow []FilterRule
+	// Deny prevents the series matching any rule from being published
+	Deny []FilterRule
+	// Relabel is applied in order to the labels of each published series
+	Relabel []RelabelRule
+}
+
+// FilterRule matches the series related to a given pod network.
+// Empty fields match everything.
+type FilterRule struct {
+	Namespaces  []string
+	NetworkName *regexp.Regexp
+	Interface   *regexp.Regexp
+}
+
+// RelabelAction is the action performed by a relabel rule.
+type RelabelAction string
+
+const (
+	// RelabelDrop drops the series whose label matches the regex
+	RelabelDrop RelabelAction = "drop"
+	// RelabelRename renames the label to the target label
+	RelabelRename RelabelAction = "rename"
+	// RelabelReplace replaces the value of the label matching the regex
+	// with the replacement, expanding the capture groups
+	RelabelReplace RelabelAction = "replace"
+)
+
+// RelabelRule changes the labels of the published series.
+type RelabelRule struct {
+	Action      RelabelAction
+	Label       string
+	Regex       *regexp.Regexp
+	TargetLabel string
+	Replacement string
+}
+
+func (r FilterRule) matches(namespace, networkName, iface string) bool {
+	if len(r.Namespaces) > 0 {
+		found := false
+		for _, n := range r.Namespaces {
+			if n == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.NetworkName != nil && !r.NetworkName.MatchString(networkName) {
+		return false
+	}
+	if r.Interface != nil && !r.Interface.MatchString(iface) {
+		return false
+	}
+	return true
+}
+
+// allowed tells if the series related to the given pod network can be published.
+func (o LabelOptions) allowed(namespace, networkName, iface string) bool {
+	for _, r := range o.Deny {
+		if r.matches(namespace, networkName, iface) {
+			return false
+		}
+	}
+	if len(o.Allow) == 0 {
+		return true
+	}
+	for _, r := range o.Allow {
+		if r.matches(namespace, networkName, iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// relabel applies the relabel rules to the given labels, returning the
+// resulting labels or false if the series must be dropped.
+func (o LabelOptions) relabel(names, values []string) ([]string, []string, bool) {
+	if len(o.Relabel) == 0 {
+		return names, values, true
+	}
+	names = append([]string{}, names...)
+	values = append([]string{}, values...)
+	for _, r := range o.Relabel {
+		index := -1
+		for i, n := range names {
+			if n == r.Label {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			continue
+		}
+
+		switch r.Action {
+		case RelabelDrop:
+			if r.Regex == nil || r.Regex.MatchString(values[index]) {
+				return nil, nil, false
+			}
+		case RelabelRename:
+			// an already existing target label is overwritten
+			for i, n := range names {
+				if n == r.TargetLabel && i != index {
+					names = append(names[:i], names[i+1:]...)
+					values = append(values[:i], values[i+1:]...)
+					if i < index {
+						index--
+					}
+					break
+				}
+			}
+			names[index] = r.TargetLabel
+		case RelabelReplace:
+			if r.Regex == nil {
+				values[index] = r.Replacement
+				continue
+			}
+			match := r.Regex.FindStringSubmatchIndex(values[index])
+			if match == nil {
+				continue
+			}
+			values[index] = string(r.Regex.ExpandString(nil, r.Replacement, values[index], match))
+		}
+	}
+	return names, values, true
+}
+
+// Emitter sends the metrics of a single scrape, applying the label options.
+// The descriptors are built according to the resulting label names, and the
+// series whose labels are already sent in the same scrape are skipped, which
+// may happen with a malformed network status or after relabeling.
+type Emitter struct {
+	ch      chan<- prometheus.Metric
+	options LabelOptions
+	descs   map[string]*prometheus.Desc
+	sent    map[string]bool
+}
+
+// NewEmitter returns an emitter sending to the given channel.
+func NewEmitter(ch chan<- prometheus.Metric, options LabelOptions) *Emitter {
+	return &Emitter{
+		ch:      ch,
+		options: options,
+		descs:   make(map[string]*prometheus.Desc),
+		sent:    make(map[string]bool),
+	}
+}
+
+// Emit sends the given series, unless it is filtered out by the label options.
+// The namespace, network_name and interface labels are used for filtering.
+func (e *Emitter) Emit(name, help string, valueType prometheus.ValueType, value float64, labelNames []string, labelValues ...string) {
+	var namespace, networkName, iface string
+	for i, n := range labelNames {
+		switch n {
+		case "namespace":
+			namespace = labelValues[i]
+		case "network_name":
+			networkName = labelValues[i]
+		case "interface":
+			iface = labelValues[i]
+		}
+	}
+	if !e.options.allowed(namespace, networkName, iface) {
+		return
+	}
+	e.EmitUnfiltered(name, help, valueType, value, labelNames, labelValues...)
+}
+
+// EmitUnfiltered sends the given series applying the relabel rules only. It is
+// meant for the aggregated series, whose sources are expected to be filtered
+// already.
+func (e *Emitter) EmitUnfiltered(name, help string, valueType prometheus.ValueType, value float64, labelNames []string, labelValues ...string) {
+	e.emit(name, help, valueType, value, time.Time{}, nil, labelNames, labelValues)
+}
+
+// EmitCounter sends the given counter series applying the relabel rules only,
+// together with its created timestamp and exemplar, if set.
+func (e *Emitter) EmitCounter(name, help string, value float64, created time.Time, exemplar *prometheus.Exemplar, labelNames []string, labelValues ...string) {
+	e.emit(name, help, prometheus.CounterValue, value, created, exemplar, labelNames, labelValues)
+}
+
+func (e *Emitter) emit(name, help string, valueType prometheus.ValueType, value float64, created time.Time, exemplar *prometheus.Exemplar, labelNames, labelValues []string) {
+	names, values, ok := e.options.relabel(labelNames, labelValues)
+	if !ok {
+		return
+	}
+
+	descKey := name + "\xff" + strings.Join(names, "\xff")
+	key := descKey + "\xff\xff" + strings.Join(values, "\xff")
+	if e.sent[key] {
+		return
+	}
+	e.sent[key] = true
+
+	desc, ok := e.descs[descKey]
+	if !ok {
+		desc = prometheus.NewDesc(name, help, names, e.options.ConstLabels)
+		e.descs[descKey] = desc
+	}
+
+	// an invalid series, i.e. with a label clashing with a const label, is
+	// reported as a collection error instead of crashing the scrape
+	var metric prometheus.Metric
+	var err error
+	if created.IsZero() {
+		metric, err = prometheus.NewConstMetric(desc, valueType, value, values...)
+	} else {
+		metric, err = prometheus.NewConstMetricWithCreatedTimestamp(desc, valueType, value, created, values...)
+	}
+	if err != nil {
+		e.ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+	if exemplar != nil {
+		withExemplar, err := prometheus.NewMetricWithExemplars(metric, *exemplar)
+		if err != nil {
+			klog.Warningf("Dropping invalid exemplar for %s: %v", name, err)
+		} else {
+			metric = withExemplar
+		}
+	}
+	e.ch <- metric
+}
@@ -0,0 +1,267 @@
+package podmetrics_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var labelOptionsTests = []struct {
+	testName        string
+	options         podmetrics.LabelOptions
+	setMetrics      func(p *podmetrics.PodMetrics)
+	expectedMetrics string
+}{
+	{
+		"deny by network name",
+		podmetrics.LabelOptions{
+			Deny: []podmetrics.FilterRule{
+				{NetworkName: regexp.MustCompile("^tenant-.*/.*$")},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "net1", NetworkName: "tenant-a/secret"},
+			})
+		},
+		`
+			pod_network_name_info{interface="eth0",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+		`,
+	},
+	{
+		"allow by namespace and interface",
+		podmetrics.LabelOptions{
+			Allow: []podmetrics.FilterRule{
+				{Namespaces: []string{"namespacename"}, Interface: regexp.MustCompile("^net[0-9]+$")},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+			})
+			p.UpdateForPod("podname", "othernamespace", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+			})
+		},
+		`
+			pod_network_name_info{interface="net1",namespace="namespacename",network_name="namespace1/secondNAD",pod="podname"} 0
+		`,
+	},
+	{
+		"deny wins over allow",
+		podmetrics.LabelOptions{
+			Allow: []podmetrics.FilterRule{
+				{Namespaces: []string{"namespacename"}},
+			},
+			Deny: []podmetrics.FilterRule{
+				{Interface: regexp.MustCompile("^eth0$")},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+			})
+		},
+		`
+			pod_network_name_info{interface="net1",namespace="namespacename",network_name="namespace1/secondNAD",pod="podname"} 0
+		`,
+	},
+	{
+		"replace rename and drop",
+		podmetrics.LabelOptions{
+			Relabel: []podmetrics.RelabelRule{
+				{Action: podmetrics.RelabelReplace, Label: "network_name", Regex: regexp.MustCompile("^tenant-[a-z]+/(.*)$"), Replacement: "tenant/$1"},
+				{Action: podmetrics.RelabelRename, Label: "network_name", TargetLabel: "nad"},
+				{Action: podmetrics.RelabelDrop, Label: "interface", Regex: regexp.MustCompile("^eth0$")},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "net1", NetworkName: "tenant-a/macvlan"},
+				{Interface: "net2", NetworkName: "namespace1/secondNAD"},
+			})
+		},
+		`
+			pod_network_name_info{interface="net1",nad="tenant/macvlan",namespace="namespacename",pod="podname"} 0
+			pod_network_name_info{interface="net2",nad="namespace1/secondNAD",namespace="namespacename",pod="podname"} 0
+		`,
+	},
+	{
+		"replace merging series",
+		podmetrics.LabelOptions{
+			Relabel: []podmetrics.RelabelRule{
+				{Action: podmetrics.RelabelReplace, Label: "network_name", Regex: regexp.MustCompile("^tenant-[a-z]+/(.*)$"), Replacement: "tenant/$1"},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "net1", NetworkName: "tenant-a/macvlan"},
+			})
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+				{Interface: "net1", NetworkName: "tenant-a/macvlan"},
+				{Interface: "net1", NetworkName: "tenant-b/macvlan"},
+			})
+		},
+		`
+			pod_network_name_info{interface="net1",namespace="namespacename",network_name="tenant/macvlan",pod="podname"} 0
+		`,
+	},
+	{
+		"relabeled series deleted with the pod",
+		podmetrics.LabelOptions{
+			Relabel: []podmetrics.RelabelRule{
+				{Action: podmetrics.RelabelReplace, Label: "network_name", Regex: regexp.MustCompile("^tenant-[a-z]+/(.*)$"), Replacement: "tenant/$1"},
+				{Action: podmetrics.RelabelRename, Label: "pod", TargetLabel: "workload"},
+			},
+		},
+		func(p *podmetrics.PodMetrics) {
+			p.UpdateForPod("podname1", "namespacename", "podname1-uid", nil, []podnetwork.Network{
+				{Interface: "net1", NetworkName: "tenant-a/macvlan"},
+			})
+			p.UpdateForPod("podname2", "namespacename", "podname2-uid", nil, []podnetwork.Network{
+				{Interface: "net1", NetworkName: "tenant-b/macvlan"},
+			})
+			p.DeleteAllForPod("podname1", "namespacename", "podname1-uid")
+		},
+		`
+			pod_network_name_info{interface="net1",namespace="namespacename",network_name="tenant/macvlan",workload="podname2"} 0
+		`,
+	},
+}
+
+func TestLabelOptions(t *testing.T) {
+
+	const metadata = `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	`
+
+	for _, tst := range labelOptionsTests {
+		tst := tst
+		t.Run(tst.testName, func(t *testing.T) {
+			t.Parallel()
+			p := podmetrics.New()
+			options := podmetrics.DefaultOptions()
+			options.Labels = tst.options
+			p.SetOptions(options)
+			tst.setMetrics(p)
+			err := testutil.CollectAndCompare(p, strings.NewReader(metadata+tst.expectedMetrics), "pod_network_name_info")
+			if err != nil {
+				t.Error("Failed to collect metrics", tst.testName, err)
+			}
+		})
+	}
+}
+
+func TestRelabeledSeriesDeleted(t *testing.T) {
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.Labels = podmetrics.LabelOptions{
+		Relabel: []podmetrics.RelabelRule{
+			{Action: podmetrics.RelabelRename, Label: "network_name", TargetLabel: "nad"},
+		},
+	}
+	p.SetOptions(options)
+	p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "tenant-a/macvlan", IPs: []string{"10.0.0.1"}, DeviceInfo: &podnetwork.DeviceInfo{Type: "pci"}},
+	})
+	if count := testutil.CollectAndCount(p, "pod_network_name_info", "pod_network_ip_info", "pod_network_device_info"); count != 3 {
+		t.Error("Expected 3 metrics, got", count)
+	}
+	p.DeleteAllForPod("podname", "namespacename", "podname-uid")
+	if count := testutil.CollectAndCount(p, "pod_network_name_info", "pod_network_ip_info", "pod_network_device_info"); count != 0 {
+		t.Error("Expected no metrics after delete, got", count)
+	}
+}
+
+func TestPodLabelAllowlist(t *testing.T) {
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.PodLabelAllowlist = []string{"app", "app.kubernetes.io/name", "app-kubernetes-io/name"}
+	p.SetOptions(options)
+
+	networks := []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	}
+	p.UpdateForPod("podname1", "namespacename", "podname1-uid", map[string]string{
+		"app":                    "app1",
+		"app.kubernetes.io/name": "name1",
+		"app-kubernetes-io/name": "conflicting",
+		"pod-template-hash":      "notallowed",
+	}, networks)
+	p.UpdateForPod("podname2", "namespacename", "podname2-uid", map[string]string{
+		"app": "app2",
+	}, networks)
+
+	expected := `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="net1",label_app="app1",label_app_kubernetes_io_name="conflicting",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname1"} 0
+	pod_network_name_info{interface="net1",label_app="app2",label_app_kubernetes_io_name="",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname2"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	// label values changes are reflected
+	p.UpdateForPod("podname2", "namespacename", "podname2-uid", map[string]string{
+		"app": "app2-new",
+	}, networks)
+	p.DeleteAllForPod("podname1", "namespacename", "podname1-uid")
+	expected = `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="net1",label_app="app2-new",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname2"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	options.PodLabelAllowlist = []string{"*"}
+	p.SetOptions(options)
+	expected = `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="net1",label_app="app2-new",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname2"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestInvalidLabels(t *testing.T) {
+	options := []podmetrics.LabelOptions{
+		{ConstLabels: map[string]string{"__x": "a"}},
+		{
+			ConstLabels: map[string]string{"cluster": "a"},
+			Relabel: []podmetrics.RelabelRule{
+				{Action: podmetrics.RelabelRename, Label: "network_name", TargetLabel: "cluster"},
+			},
+		},
+	}
+	for _, o := range options {
+		p := podmetrics.New()
+		metricsOptions := podmetrics.DefaultOptions()
+		metricsOptions.Labels = o
+		p.SetOptions(metricsOptions)
+		p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+			{Interface: "net1", NetworkName: "tenant-a/macvlan"},
+		})
+
+		// the invalid series are reported as errors, without panicking
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(p)
+		if _, err := registry.Gather(); err == nil {
+			t.Error("Expected an error gathering the metrics with", o)
+		}
+	}
+}
@@ -1,116 +1,639 @@
 package podmetrics
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/openshift/network-metrics-daemon/pkg/exposition"
+	"github.com/openshift/network-metrics-daemon/pkg/netattachdef"
 	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 )
 
-const (
-	metricStoreInitSize int = 330
-	initialMetricsCount int = 0
-	metricsIncVal       int = 1
-)
-
 type podKey struct {
 	name      string
 	namespace string
 }
 
-var podNetworks = make(map[podKey][]podnetwork.Network)
-var mtx sync.Mutex
-
 var (
-	// NetAttachDefPerPod represent the network attachment definitions bound to a given
+	netAttachDefLabels = []string{"pod",
+		"namespace",
+		"interface",
+		"network_name"}
+
+	// netAttachDefMetadataLabels are added to pod_network_name_info when
+	// the network attachment definitions are resolved
+	netAttachDefMetadataLabels = []string{"plugin_type",
+		"resource_name"}
+
+	ipLabels = []string{"pod",
+		"namespace",
+		"interface",
+		"network_name",
+		"ip",
+		"family"}
+
+	attachmentMissingLabels = []string{"pod",
+		"namespace",
+		"network_name"}
+
+	deviceLabelNames = []string{"pod",
+		"namespace",
+		"interface",
+		"network_name",
+		"type",
+		"pci_address",
+		"pf_pci_address",
+		"rdma_device",
+		"vhost_net",
+		"representor_device",
+		"vdpa_parent_device",
+		"vdpa_driver",
+		"vdpa_path",
+		"socket_path",
+		"socket_mode",
+		"socket_role"}
+)
+
+const (
+	// netAttachDefPerPod represent the network attachment definitions bound to a given
 	// pod
-	NetAttachDefPerPod = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "pod_network_name_info",
-			Help: "Metric to identify network names of networks added to pods.",
-		}, []string{"pod",
-			"namespace",
-			"interface",
-			"network_name"})
+	netAttachDefPerPod     = "pod_network_name_info"
+	netAttachDefPerPodHelp = "Metric to identify network names of networks added to pods."
+
+	// ipPerPodNetwork represents the ip addresses assigned to a given
+	// interface of a pod
+	ipPerPodNetwork     = "pod_network_ip_info"
+	ipPerPodNetworkHelp = "Metric to identify ip addresses assigned to the networks added to pods."
+
+	// devicePerPodNetwork represents the device backing a given network
+	// attachment of a pod, as reported in the device-info section of the
+	// network status annotation
+	devicePerPodNetwork     = "pod_network_device_info"
+	devicePerPodNetworkHelp = "Metric to identify the devices backing the networks added to pods."
+
+	// attachmentsPerNetwork represents the number of pod network attachments
+	// to non default networks on the node, for each network
+	attachmentsPerNetwork     = "node_network_attachments"
+	attachmentsPerNetworkHelp = "Number of pod attachments to secondary networks on the node, for each network."
+
+	// podsWithSecondaryNetworks represents the number of pods on the node
+	// attached to at least one non default network
+	podsWithSecondaryNetworks     = "node_pods_with_secondary_networks"
+	podsWithSecondaryNetworksHelp = "Number of pods on the node attached to at least one secondary network."
+
+	// attachmentMissing represents the network attachments requested by
+	// a given pod that are missing from its network status
+	attachmentMissing     = "pod_network_attachment_missing"
+	attachmentMissingHelp = "Number of attachments to the network requested by the pod and missing from its network status."
+
+	// attachmentMismatches represents the number of network attachments
+	// found missing from the network status of the pods on the node
+	attachmentMismatches     = "node_network_attachment_mismatches_total"
+	attachmentMismatchesHelp = "Number of requested network attachments found missing from the network status of the pods on the node."
+
+	// statusParseErrors represents the number of failures parsing the
+	// network status of the pods on the node
+	statusParseErrors     = "node_network_status_parse_errors_total"
+	statusParseErrorsHelp = "Number of failures parsing the network status annotation of the pods on the node."
 )
 
-// UpdateForPod adds metrics for all the provided networks to the given pod.
-func UpdateForPod(podName, namespace string, networks []podnetwork.Network) {
-	for _, n := range networks {
-		if n.Interface == "" {
-			// as we are interested in netlink interfaces
-			// only, we are skipping networks with no interface
-			continue
-		}
+// Options controls which metrics are published, and how.
+type Options struct {
+	// NetworkName enables pod_network_name_info
+	NetworkName bool
+	// IP enables pod_network_ip_info
+	IP bool
+	// Device enables pod_network_device_info
+	Device bool
+	// AttachmentMissing enables pod_network_attachment_missing
+	AttachmentMissing bool
+	// UIDLabel adds the uid of the pods to the pod level metrics
+	UIDLabel bool
+	// Labels controls which series are published and their labels
+	Labels LabelOptions
+	// PodLabelAllowlist contains the pod labels added to pod_network_name_info
+	// as label_<sanitized name>. "*" allows all the labels.
+	PodLabelAllowlist []string
+	// NADLabelAllowlist contains the network attachment definition labels
+	// added to pod_network_name_info as nad_label_<sanitized name>, when the
+	// definitions are resolved. "*" allows all the labels.
+	NADLabelAllowlist []string
+}
+
+// DefaultOptions returns the options enabling all the metrics.
+func DefaultOptions() Options {
+	return Options{
+		NetworkName:       true,
+		IP:                true,
+		Device:            true,
+		AttachmentMissing: true,
+	}
+}
+
+// NetworkResolver finds the network attachment definition a network
+// of a pod in the given namespace refers to.
+type NetworkResolver interface {
+	Resolve(podNamespace, networkName string) (netattachdef.Metadata, bool)
+}
+
+// PodMetrics keeps track of the networks of the pods running on the node,
+// and publishes them as metrics. It implements prometheus.Collector, the
+// metrics are generated on each scrape from a snapshot of the known pods.
+type PodMetrics struct {
+	mtx         sync.RWMutex
+	podNetworks map[podKey]podInfo
+	// missing contains the number of missing attachments
+	// of each network requested by the pods
+	missing     map[podKey]missingInfo
+	options     Options
+	resolver    NetworkResolver
+	created     time.Time
+	parseErrors uint64
+	mismatches  uint64
+	// lastParseError is the exemplar of the parse errors counter,
+	// carrying the uid of the last pod whose status failed to be parsed
+	lastParseError *prometheus.Exemplar
+	// lastMismatch is the exemplar of the attachment mismatches counter,
+	// carrying the uid of the last pod with a newly missing attachment
+	lastMismatch *prometheus.Exemplar
+}
+
+// podInfo contains what is known about a given pod. As a pod can be
+// recreated with the same name, only the incarnation with the given
+// uid is tracked.
+type podInfo struct {
+	uid      types.UID
+	labels   map[string]string
+	networks []podnetwork.Network
+}
+
+// missingInfo contains the number of missing attachments of each
+// network requested by the pod with the given uid.
+type missingInfo struct {
+	uid    types.UID
+	counts map[string]int
+}
+
+// New returns a new PodMetrics with no pods, publishing all the metrics.
+func New() *PodMetrics {
+	return &PodMetrics{
+		podNetworks: make(map[podKey]podInfo),
+		missing:     make(map[podKey]missingInfo),
+		options:     DefaultOptions(),
+		created:     time.Now(),
+	}
+}
+
+// SetOptions changes the options used to publish the metrics. They
+// apply starting from the next scrape.
+func (p *PodMetrics) SetOptions(options Options) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.options = options
+}
+
+// SetNetworkResolver enables the resolution of the network attachment
+// definitions, adding the plugin type, the resource name and the labels
+// in the allowlist, prefixed by nad_, of the definitions to
+// pod_network_name_info.
+func (p *PodMetrics) SetNetworkResolver(resolver NetworkResolver) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.resolver = resolver
+}
 
-		labels := prometheus.Labels{
-			"pod":          podName,
-			"namespace":    namespace,
-			"interface":    n.Interface,
-			"network_name": n.NetworkName,
+// UpdateForPod publishes metrics for all the provided networks of the given pod,
+// replacing the ones previously published for the same pod, including the ones
+// of a previous pod with the same name. The pod labels are published according
+// to the pod label allowlist.
+func (p *PodMetrics) UpdateForPod(podName, namespace string, uid types.UID, podLabels map[string]string, networks []podnetwork.Network) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.podNetworks[podKey{podName, namespace}] = podInfo{uid, podLabels, networks}
+}
+
+// UpdateMissingForPod publishes the requested networks of the given pod
+// missing from its network status, replacing the ones previously published.
+// The attachments not already known as missing are counted as mismatches.
+func (p *PodMetrics) UpdateMissingForPod(podName, namespace string, uid types.UID, missing []podnetwork.RequestedNetwork) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	key := podKey{podName, namespace}
+	if len(missing) == 0 {
+		delete(p.missing, key)
+		return
+	}
+
+	var previous map[string]int
+	if current, ok := p.missing[key]; ok && current.uid == uid {
+		previous = current.counts
+	}
+	counts := make(map[string]int)
+	for _, m := range missing {
+		counts[m.NetworkName]++
+	}
+	for name, count := range counts {
+		if count > previous[name] {
+			p.mismatches += uint64(count - previous[name])
+			p.lastMismatch = &prometheus.Exemplar{
+				Value:     1,
+				Labels:    prometheus.Labels{"pod_uid": string(uid)},
+				Timestamp: time.Now(),
+			}
 		}
-		NetAttachDefPerPod.With(labels).Add(0)
 	}
-	mtx.Lock()
-	defer mtx.Unlock()
-	podNetworks[podKey{podName, namespace}] = networks
+	p.missing[key] = missingInfo{uid, counts}
 }
 
 // DeleteAllForPod stop publishing all the network metrics related to the
-// given pod.
-func DeleteAllForPod(podName, namespace string) {
-	mtx.Lock()
-	defer mtx.Unlock()
-	nets, ok := podNetworks[podKey{podName, namespace}]
-	if !ok {
-		return
+// given pod. Nothing is deleted if the pod was replaced by a new one with
+// the same name, but a different uid.
+func (p *PodMetrics) DeleteAllForPod(podName, namespace string, uid types.UID) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	key := podKey{podName, namespace}
+	if info, ok := p.podNetworks[key]; ok && info.uid == uid {
+		delete(p.podNetworks, key)
+	}
+	if info, ok := p.missing[key]; ok && info.uid == uid {
+		delete(p.missing, key)
+	}
+}
+
+// PCIAttachment is a network of a pod backed by a PCI device.
+type PCIAttachment struct {
+	Pod         string
+	Namespace   string
+	Interface   string
+	NetworkName string
+	PCIAddress  string
+	// PFPCIAddress is the address of the physical function, if reported
+	PFPCIAddress string
+}
+
+// PCIAttachments returns the networks of the known pods
+// backed by a PCI device, as reported in their device info.
+func (p *PodMetrics) PCIAttachments() []PCIAttachment {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	var res []PCIAttachment
+	for k, info := range p.podNetworks {
+		for _, n := range info.networks {
+			if n.DeviceInfo.PciAddress() == "" {
+				continue
+			}
+			res = append(res, PCIAttachment{
+				Pod:          k.name,
+				Namespace:    k.namespace,
+				Interface:    n.Interface,
+				NetworkName:  n.NetworkName,
+				PCIAddress:   n.DeviceInfo.PciAddress(),
+				PFPCIAddress: n.DeviceInfo.PfPciAddress(),
+			})
+		}
+	}
+	return res
+}
+
+// StatusParseFailed records a failure parsing the network status of the pod
+// with the given uid.
+func (p *PodMetrics) StatusParseFailed(uid types.UID) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.parseErrors++
+	p.lastParseError = &prometheus.Exemplar{
+		Value:     1,
+		Labels:    prometheus.Labels{"pod_uid": string(uid)},
+		Timestamp: time.Now(),
+	}
+}
+
+// Describe implements prometheus.Collector. As the published metrics
+// depend on the options that can change at runtime, PodMetrics is an
+// unchecked collector and no descriptor is sent.
+func (p *PodMetrics) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector.
+func (p *PodMetrics) Collect(ch chan<- prometheus.Metric) {
+	p.mtx.RLock()
+	snapshot := make(map[podKey]podInfo, len(p.podNetworks))
+	for k, info := range p.podNetworks {
+		snapshot[k] = info
+	}
+	missing := make(map[podKey]missingInfo, len(p.missing))
+	for k, info := range p.missing {
+		missing[k] = info
+	}
+	mismatches, lastMismatch := p.mismatches, p.lastMismatch
+	options, resolver := p.options, p.resolver
+	parseErrors, lastParseError := p.parseErrors, p.lastParseError
+	p.mtx.RUnlock()
+
+	// all the series of the same metric must have the same label names, so
+	// the pods lacking some of the allowed labels get them with an empty value
+	podLabels := make(map[podKey]map[string]string, len(snapshot))
+	podLabelNames := make(map[string]bool)
+	for k, info := range snapshot {
+		podLabels[k] = allowedLabels(info.labels, options.PodLabelAllowlist, sanitizePodLabel)
+		for name := range podLabels[k] {
+			podLabelNames[name] = true
+		}
+	}
+
+	// the definitions are resolved once per scrape, so that the
+	// changes to them are reflected in the published series
+	netAttachDefs := make(map[podKey][]netattachdef.Metadata, len(snapshot))
+	nadLabelNames := make(map[string]bool)
+	if resolver != nil && options.NetworkName {
+		for k, info := range snapshot {
+			metadata := make([]netattachdef.Metadata, len(info.networks))
+			for i, n := range info.networks {
+				metadata[i], _ = resolver.Resolve(k.namespace, n.NetworkName)
+				metadata[i].Labels = allowedLabels(metadata[i].Labels, options.NADLabelAllowlist, sanitizeNADLabel)
+				for name := range metadata[i].Labels {
+					nadLabelNames[name] = true
+				}
+			}
+			netAttachDefs[k] = metadata
+		}
+	}
+
+	sortedNADLabelNames := sortedKeys(nadLabelNames)
+	netAttachDefNames := withUIDName(netAttachDefLabels, options.UIDLabel)
+	if resolver != nil {
+		netAttachDefNames = append(netAttachDefNames, netAttachDefMetadataLabels...)
+		netAttachDefNames = append(netAttachDefNames, sortedNADLabelNames...)
+	}
+	sortedPodLabelNames := sortedKeys(podLabelNames)
+	netAttachDefNames = append(netAttachDefNames, sortedPodLabelNames...)
+
+	deviceNames := withUIDName(deviceLabelNames, options.UIDLabel)
+	ipNames := withUIDName(ipLabels, options.UIDLabel)
+	emitter := NewEmitter(ch, options.Labels)
+	for k, info := range snapshot {
+		podLabelValues := make([]string, 0, len(podLabelNames))
+		for _, name := range sortedPodLabelNames {
+			podLabelValues = append(podLabelValues, podLabels[k][name])
+		}
+
+		for i, n := range info.networks {
+			if options.Device && n.DeviceInfo != nil {
+				// devices used by userspace networking (i.e. dpdk) might
+				// not come with an interface, so we publish them anyway
+				emitter.Emit(devicePerPodNetwork, devicePerPodNetworkHelp, prometheus.GaugeValue, 0,
+					deviceNames, withUIDValue(deviceLabels(k.name, k.namespace, n), options.UIDLabel, info.uid)...)
+			}
+
+			if n.Interface == "" {
+				// as we are interested in netlink interfaces
+				// only, we are skipping networks with no interface
+				continue
+			}
+			if options.NetworkName {
+				values := withUIDValue([]string{k.name, k.namespace, n.Interface, n.NetworkName}, options.UIDLabel, info.uid)
+				if resolver != nil {
+					values = append(values, netAttachDefValues(netAttachDefs[k][i], sortedNADLabelNames)...)
+				}
+				values = append(values, podLabelValues...)
+				emitter.Emit(netAttachDefPerPod, netAttachDefPerPodHelp, prometheus.GaugeValue, 0,
+					netAttachDefNames, values...)
+			}
+
+			if !options.IP {
+				continue
+			}
+			for _, ip := range n.IPs {
+				family := ipFamily(ip)
+				if family == "" {
+					klog.Warningf("Skipping invalid ip %s for pod %s/%s interface %s", ip, k.namespace, k.name, n.Interface)
+					continue
+				}
+				emitter.Emit(ipPerPodNetwork, ipPerPodNetworkHelp, prometheus.GaugeValue, 0,
+					ipNames, withUIDValue([]string{k.name, k.namespace, n.Interface, n.NetworkName, ip, family}, options.UIDLabel, info.uid)...)
+			}
+		}
+	}
+
+	if options.AttachmentMissing {
+		missingNames := withUIDName(attachmentMissingLabels, options.UIDLabel)
+		for k, info := range missing {
+			for name, count := range info.counts {
+				emitter.Emit(attachmentMissing, attachmentMissingHelp, prometheus.GaugeValue, float64(count),
+					missingNames, withUIDValue([]string{k.name, k.namespace, name}, options.UIDLabel, info.uid)...)
+			}
+		}
 	}
 
-	delete(podNetworks, podKey{podName, namespace})
+	collectNodeSummary(emitter, snapshot, options.Labels)
+	emitter.EmitCounter(attachmentMismatches, attachmentMismatchesHelp, float64(mismatches), p.created, lastMismatch, nil)
+	emitter.EmitCounter(statusParseErrors, statusParseErrorsHelp, float64(parseErrors), p.created, lastParseError, nil)
+}
+
+// withUIDName returns the given label names followed by uid, if enabled.
+func withUIDName(labelNames []string, enabled bool) []string {
+	res := append([]string{}, labelNames...)
+	if enabled {
+		res = append(res, "uid")
+	}
+	return res
+}
+
+// withUIDValue returns the given label values followed by the uid, if enabled.
+func withUIDValue(labelValues []string, enabled bool, uid types.UID) []string {
+	if enabled {
+		labelValues = append(labelValues, string(uid))
+	}
+	return labelValues
+}
+
+// netAttachDefValues returns the values of the labels describing the given
+// definition, whose labels are keyed by their sanitized name, followed by the
+// values of the given sanitized definition labels.
+func netAttachDefValues(metadata netattachdef.Metadata, labelNames []string) []string {
+	res := []string{metadata.PluginType, metadata.ResourceName}
+	for _, name := range labelNames {
+		res = append(res, metadata.Labels[name])
+	}
+	return res
+}
 
-	for _, n := range nets {
-		labels := prometheus.Labels{
-			"pod":          podName,
-			"namespace":    namespace,
-			"interface":    n.Interface,
-			"network_name": n.NetworkName,
+// collectNodeSummary publishes the metrics aggregating the networks of all the
+// pods on the node. Only the networks allowed by the filters are accounted.
+func collectNodeSummary(emitter *Emitter, snapshot map[podKey]podInfo, options LabelOptions) {
+	attachments := make(map[string]int)
+	podsWithSecondary := 0
+	for k, info := range snapshot {
+		hasSecondary := false
+		for _, n := range info.networks {
+			if n.Default || !options.allowed(k.namespace, n.NetworkName, n.Interface) {
+				continue
+			}
+			attachments[n.NetworkName]++
+			hasSecondary = true
 		}
-		NetAttachDefPerPod.Delete(labels)
+		if hasSecondary {
+			podsWithSecondary++
+		}
+	}
+
+	for name, count := range attachments {
+		emitter.EmitUnfiltered(attachmentsPerNetwork, attachmentsPerNetworkHelp, prometheus.GaugeValue, float64(count),
+			[]string{"network_name"}, name)
+	}
+	emitter.EmitUnfiltered(podsWithSecondaryNetworks, podsWithSecondaryNetworksHelp, prometheus.GaugeValue, float64(podsWithSecondary), nil)
+}
+
+// deviceLabels returns the values of the labels describing the device
+// backing the given network, in the order of deviceLabelNames.
+func deviceLabels(podName, namespace string, n podnetwork.Network) []string {
+	var rdmaDevice, vhostNet, representorDevice string
+	var vdpaParentDevice, vdpaDriver, vdpaPath string
+	var socketPath, socketMode, socketRole string
+
+	if pci := n.DeviceInfo.Pci; pci != nil {
+		rdmaDevice = pci.RdmaDevice
+		vhostNet = pci.Vhostnet
+		representorDevice = pci.RepresentorDevice
+	}
+	if vdpa := n.DeviceInfo.Vdpa; vdpa != nil {
+		representorDevice = vdpa.RepresentorDevice
+		vdpaParentDevice = vdpa.ParentDevice
+		vdpaDriver = vdpa.Driver
+		vdpaPath = vdpa.Path
+	}
+	if vhostUser := n.DeviceInfo.VhostUser; vhostUser != nil {
+		socketPath = vhostUser.Path
+		socketMode = vhostUser.Mode
+	}
+	if memif := n.DeviceInfo.Memif; memif != nil {
+		socketPath = memif.Path
+		socketMode = memif.Mode
+		socketRole = memif.Role
+	}
+
+	return []string{
+		podName,
+		namespace,
+		n.Interface,
+		n.NetworkName,
+		n.DeviceInfo.Type,
+		n.DeviceInfo.PciAddress(),
+		n.DeviceInfo.PfPciAddress(),
+		rdmaDevice,
+		vhostNet,
+		representorDevice,
+		vdpaParentDevice,
+		vdpaDriver,
+		vdpaPath,
+		socketPath,
+		socketMode,
+		socketRole,
+	}
+}
+
+// ipFamily returns the family of the given ip address, or an empty
+// string if the address is not valid.
+func ipFamily(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
 	}
 }
 
-// Serve serves the network metrics to the given address.
-func Serve(metricsAddress string, stopCh <-chan struct{}) {
+// ServerOptions controls how the metrics are served.
+type ServerOptions struct {
+	// Address is the address to listen on
+	Address string
+	// ShutdownTimeout is how long the in flight requests are
+	// waited for when shutting down
+	ShutdownTimeout time.Duration
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+	// Authorize, when set, wraps the metrics handler so that
+	// only the authorized requests are served
+	Authorize func(http.Handler) http.Handler
+	// DisableMetrics disables the /metrics endpoint, i.e. when the
+	// metrics are exported via OTLP only
+	DisableMetrics bool
+}
+
+// Serve serves the network metrics produced by the given collectors
+// according to the given options, together with the additional handlers indexed by path.
+// Unless a handler is provided for it, /healthz always reports success.
+// It blocks until ctx is cancelled, and then shuts the server down gracefully,
+// returning an error if the in flight requests do not complete within the
+// shutdown timeout.
+func Serve(ctx context.Context, options ServerOptions, handlers map[string]http.Handler, collectors ...prometheus.Collector) error {
 
-	// Including these stats kills performance when Prometheus polls with multiple targets
-	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
-	prometheus.Unregister(prometheus.NewGoCollector())
+	// A dedicated registry is used so that the go and process stats are not
+	// included, as they kill performance when Prometheus polls with multiple targets
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		registry.MustRegister(c)
+	}
 
-	prometheus.MustRegister(NetAttachDefPerPod)
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	if !options.DisableMetrics {
+		metricsHandler := exposition.Handler(registry)
+		if options.Authorize != nil {
+			metricsHandler = options.Authorize(metricsHandler)
+		}
+		mux.Handle("/metrics", metricsHandler)
+	}
+	for path, handler := range handlers {
+		mux.Handle(path, handler)
+	}
 
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(http.StatusText(http.StatusOK)))
-	})
+	if _, ok := handlers["/healthz"]; !ok {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(http.StatusText(http.StatusOK)))
+		})
+	}
 
-	klog.Info("Serving network metrics")
-	server := &http.Server{Addr: metricsAddress, Handler: mux}
-	go func() {
-		err := server.ListenAndServe()
-		if err != nil {
-			klog.Error("Failed serving network metrics", err)
+	listener, err := net.Listen("tcp", options.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", options.Address, err)
+	}
 
+	server := &http.Server{Handler: mux, TLSConfig: options.TLSConfig}
+	errCh := make(chan error, 1)
+	go func() {
+		if options.TLSConfig != nil {
+			klog.Info("Serving network metrics over TLS")
+			errCh <- server.ServeTLS(listener, "", "")
+			return
 		}
+		klog.Info("Serving network metrics")
+		errCh <- server.Serve(listener)
 	}()
 
-	go func() {
-		<-stopCh
-		klog.Info("Received stop signal, closing the network metrics endpoint")
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed serving network metrics: %w", err)
+	case <-ctx.Done():
+	}
+
+	klog.Info("Received stop signal, shutting down the network metrics endpoint")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		server.Close()
-	}()
+		return fmt.Errorf("failed to shut down the network metrics endpoint: %w", err)
+	}
+	return nil
 }
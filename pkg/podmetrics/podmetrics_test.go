@@ -1,27 +1,34 @@
 package podmetrics_test
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/openshift/network-metrics-daemon/pkg/netattachdef"
 	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
 	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 var podMetricsTests = []struct {
 	testName        string
-	setMetrics      func()
+	setMetrics      func(p *podmetrics.PodMetrics)
 	expectedMetrics string
 }{
 	{
 		"twonetworks same network name",
-		func() {
+		func(p *podmetrics.PodMetrics) {
 			networks := []podnetwork.Network{
-				{"eth0", "namespace1/firstNAD"},
-				{"eth1", "namespace1/firstNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth1", NetworkName: "namespace1/firstNAD"},
 			}
-			podmetrics.UpdateForPod("podname", "namespacename", networks)
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
 		},
 		`
 			pod_network_name_info{interface="eth0",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
@@ -30,12 +37,12 @@ var podMetricsTests = []struct {
 	},
 	{
 		"twonetworks different networkname",
-		func() {
+		func(p *podmetrics.PodMetrics) {
 			networks := []podnetwork.Network{
-				{"eth0", "namespace1/firstNAD"},
-				{"eth1", "namespace2/secondNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth1", NetworkName: "namespace2/secondNAD"},
 			}
-			podmetrics.UpdateForPod("podname", "namespacename", networks)
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
 		},
 		`
 			pod_network_name_info{interface="eth0",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
@@ -44,30 +51,30 @@ var podMetricsTests = []struct {
 	},
 	{
 		"add and delete",
-		func() {
+		func(p *podmetrics.PodMetrics) {
 			networks := []podnetwork.Network{
-				{"eth0", "namespace1/firstNAD"},
-				{"eth1", "namespace2/secondNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth1", NetworkName: "namespace2/secondNAD"},
 			}
-			podmetrics.UpdateForPod("podname", "namespacename", networks)
-			podmetrics.DeleteAllForPod("podname", "namespacename")
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+			p.DeleteAllForPod("podname", "namespacename", "podname-uid")
 		},
 		`
 		`,
 	},
 	{
 		"two pods and delete one",
-		func() {
+		func(p *podmetrics.PodMetrics) {
 			networks := []podnetwork.Network{
-				{"eth0", "namespace1/firstNAD"},
-				{"eth1", "namespace2/secondNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth1", NetworkName: "namespace2/secondNAD"},
 			}
 			networks2 := []podnetwork.Network{
-				{"eth0", "namespace1/firstNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
 			}
-			podmetrics.UpdateForPod("podname1", "namespacename", networks)
-			podmetrics.UpdateForPod("podname2", "namespacename", networks2)
-			podmetrics.DeleteAllForPod("podname1", "namespacename")
+			p.UpdateForPod("podname1", "namespacename", "podname1-uid", nil, networks)
+			p.UpdateForPod("podname2", "namespacename", "podname2-uid", nil, networks2)
+			p.DeleteAllForPod("podname1", "namespacename", "podname1-uid")
 
 		},
 		`
@@ -75,6 +82,36 @@ var podMetricsTests = []struct {
 
 		`,
 	},
+	{
+		"update replaces previous networks",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth1", NetworkName: "namespace2/secondNAD"},
+			}
+			networks2 := []podnetwork.Network{
+				{Interface: "eth1", NetworkName: "namespace2/thirdNAD"},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks2)
+		},
+		`
+			pod_network_name_info{interface="eth1",namespace="namespacename",network_name="namespace2/thirdNAD",pod="podname"} 0
+		`,
+	},
+	{
+		"duplicated network",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+		},
+		`
+			pod_network_name_info{interface="eth0",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+		`,
+	},
 }
 
 func TestPodMetrics(t *testing.T) {
@@ -85,12 +122,609 @@ func TestPodMetrics(t *testing.T) {
 	`
 
 	for _, tst := range podMetricsTests {
-		tst.setMetrics()
-		err := testutil.CollectAndCompare(podmetrics.NetAttachDefPerPod, strings.NewReader(metadata+tst.expectedMetrics))
+		tst := tst
+		t.Run(tst.testName, func(t *testing.T) {
+			t.Parallel()
+			p := podmetrics.New()
+			tst.setMetrics(p)
+			err := testutil.CollectAndCompare(p, strings.NewReader(metadata+tst.expectedMetrics), "pod_network_name_info")
+			if err != nil {
+				t.Error("Failed to collect metrics", tst.testName, err)
+			}
+		})
+	}
+}
+
+var podIPMetricsTests = []struct {
+	testName        string
+	setMetrics      func(p *podmetrics.PodMetrics)
+	expectedMetrics string
+}{
+	{
+		"dual stack network",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.10", "fd00::10"}},
+				{Interface: "eth1", NetworkName: "namespace2/secondNAD", IPs: []string{"192.168.1.200"}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+		},
+		`
+			pod_network_ip_info{family="ipv4",interface="eth0",ip="10.244.0.10",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+			pod_network_ip_info{family="ipv6",interface="eth0",ip="fd00::10",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+			pod_network_ip_info{family="ipv4",interface="eth1",ip="192.168.1.200",namespace="namespacename",network_name="namespace2/secondNAD",pod="podname"} 0
+			`,
+	},
+	{
+		"invalid ip and no interface",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"not-an-ip", "10.244.0.10"}},
+				{NetworkName: "namespace2/secondNAD", IPs: []string{"192.168.1.200"}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+		},
+		`
+			pod_network_ip_info{family="ipv4",interface="eth0",ip="10.244.0.10",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+			`,
+	},
+	{
+		"two pods and delete one",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.10"}},
+			}
+			networks2 := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.11"}},
+			}
+			p.UpdateForPod("podname1", "namespacename", "podname1-uid", nil, networks)
+			p.UpdateForPod("podname2", "namespacename", "podname2-uid", nil, networks2)
+			p.DeleteAllForPod("podname1", "namespacename", "podname1-uid")
+		},
+		`
+			pod_network_ip_info{family="ipv4",interface="eth0",ip="10.244.0.11",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname2"} 0
+			`,
+	},
+	{
+		"update twice and delete",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.10"}},
+			}
+			networks2 := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.11"}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks2)
+			p.DeleteAllForPod("podname", "namespacename", "podname-uid")
+		},
+		`
+		`,
+	},
+}
+
+func TestPodIPMetrics(t *testing.T) {
+
+	const metadata = `
+	# HELP pod_network_ip_info Metric to identify ip addresses assigned to the networks added to pods.
+	# TYPE pod_network_ip_info gauge
+	`
+
+	for _, tst := range podIPMetricsTests {
+		tst := tst
+		t.Run(tst.testName, func(t *testing.T) {
+			t.Parallel()
+			p := podmetrics.New()
+			tst.setMetrics(p)
+			err := testutil.CollectAndCompare(p, strings.NewReader(metadata+tst.expectedMetrics), "pod_network_ip_info")
+			if err != nil {
+				t.Error("Failed to collect metrics", tst.testName, err)
+			}
+		})
+	}
+}
+
+var podDeviceMetricsTests = []struct {
+	testName        string
+	setMetrics      func(p *podmetrics.PodMetrics)
+	expectedMetrics string
+}{
+	{
+		"pci and vdpa devices",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "eth0", NetworkName: "namespace1/firstNAD"},
+				{Interface: "net1", NetworkName: "namespace1/sriov", DeviceInfo: &podnetwork.DeviceInfo{
+					Type: "pci",
+					Pci:  &podnetwork.PciDevice{PciAddress: "0000:3b:02.4", PfPciAddress: "0000:3b:00.0"},
+				}},
+				{Interface: "net2", NetworkName: "namespace1/vdpa", DeviceInfo: &podnetwork.DeviceInfo{
+					Type: "vdpa",
+					Vdpa: &podnetwork.VdpaDevice{ParentDevice: "vdpa:0000:65:00.3", Driver: "vhost", Path: "/dev/vhost-vdpa-1", PciAddress: "0000:65:00.3"},
+				}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+		},
+		`
+			pod_network_device_info{interface="net1",namespace="namespacename",network_name="namespace1/sriov",pci_address="0000:3b:02.4",pf_pci_address="0000:3b:00.0",pod="podname",rdma_device="",representor_device="",socket_mode="",socket_path="",socket_role="",type="pci",vdpa_driver="",vdpa_parent_device="",vdpa_path="",vhost_net=""} 0
+			pod_network_device_info{interface="net2",namespace="namespacename",network_name="namespace1/vdpa",pci_address="0000:65:00.3",pf_pci_address="",pod="podname",rdma_device="",representor_device="",socket_mode="",socket_path="",socket_role="",type="vdpa",vdpa_driver="vhost",vdpa_parent_device="vdpa:0000:65:00.3",vdpa_path="/dev/vhost-vdpa-1",vhost_net=""} 0
+			`,
+	},
+	{
+		"vhost-user device without interface",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{NetworkName: "namespace1/vhostuser", DeviceInfo: &podnetwork.DeviceInfo{
+					Type:      "vhost-user",
+					VhostUser: &podnetwork.VhostUserDevice{Mode: "server", Path: "/var/run/vhost/net1.sock"},
+				}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+		},
+		`
+			pod_network_device_info{interface="",namespace="namespacename",network_name="namespace1/vhostuser",pci_address="",pf_pci_address="",pod="podname",rdma_device="",representor_device="",socket_mode="server",socket_path="/var/run/vhost/net1.sock",socket_role="",type="vhost-user",vdpa_driver="",vdpa_parent_device="",vdpa_path="",vhost_net=""} 0
+			`,
+	},
+	{
+		"add and delete",
+		func(p *podmetrics.PodMetrics) {
+			networks := []podnetwork.Network{
+				{Interface: "net1", NetworkName: "namespace1/sriov", DeviceInfo: &podnetwork.DeviceInfo{
+					Type: "pci",
+					Pci:  &podnetwork.PciDevice{PciAddress: "0000:3b:02.4"},
+				}},
+			}
+			p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+			p.DeleteAllForPod("podname", "namespacename", "podname-uid")
+		},
+		`
+		`,
+	},
+}
+
+func TestPodDeviceMetrics(t *testing.T) {
+
+	const metadata = `
+	# HELP pod_network_device_info Metric to identify the devices backing the networks added to pods.
+	# TYPE pod_network_device_info gauge
+	`
+
+	for _, tst := range podDeviceMetricsTests {
+		tst := tst
+		t.Run(tst.testName, func(t *testing.T) {
+			t.Parallel()
+			p := podmetrics.New()
+			tst.setMetrics(p)
+			err := testutil.CollectAndCompare(p, strings.NewReader(metadata+tst.expectedMetrics), "pod_network_device_info")
+			if err != nil {
+				t.Error("Failed to collect metrics", tst.testName, err)
+			}
+		})
+	}
+}
+
+func TestPodMetricsOptions(t *testing.T) {
+	networks := []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "namespace1/firstNAD", IPs: []string{"10.244.0.10"}, DeviceInfo: &podnetwork.DeviceInfo{Type: "pci"}},
+	}
+
+	p := podmetrics.New()
+	p.UpdateForPod("podname", "namespacename", "podname-uid", nil, networks)
+	if count := testutil.CollectAndCount(p, "pod_network_name_info", "pod_network_ip_info", "pod_network_device_info"); count != 3 {
+		t.Error("Expected 3 metrics with the default options, got", count)
+	}
+
+	p.SetOptions(podmetrics.Options{
+		NetworkName: true,
+		Labels: podmetrics.LabelOptions{
+			ConstLabels: map[string]string{"cluster": "edge1"},
+		},
+	})
+	expected := `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{cluster="edge1",interface="eth0",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestRecreatedPod(t *testing.T) {
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.UIDLabel = true
+	p.SetOptions(options)
+	p.UpdateForPod("podname", "namespacename", "uid1", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	p.UpdateForPod("podname", "namespacename", "uid2", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+	})
+	// the late delete of the old pod leaves the new one untouched
+	p.DeleteAllForPod("podname", "namespacename", "uid1")
+
+	expected := `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="net1",namespace="namespacename",network_name="namespace1/secondNAD",pod="podname",uid="uid2"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	p.DeleteAllForPod("podname", "namespacename", "uid2")
+	if count := testutil.CollectAndCount(p, "pod_network_name_info"); count != 0 {
+		t.Error("Expected no metrics after the delete, got", count)
+	}
+}
+
+func TestPCIAttachments(t *testing.T) {
+	p := podmetrics.New()
+	p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/sriov", DeviceInfo: &podnetwork.DeviceInfo{
+			Type: "pci",
+			Pci:  &podnetwork.PciDevice{PciAddress: "0000:3b:02.1", PfPciAddress: "0000:3b:00.0"},
+		}},
+	})
+	expected := []podmetrics.PCIAttachment{
+		{Pod: "podname", Namespace: "namespacename", Interface: "net1", NetworkName: "namespace1/sriov", PCIAddress: "0000:3b:02.1", PFPCIAddress: "0000:3b:00.0"},
+	}
+	if res := p.PCIAttachments(); !reflect.DeepEqual(res, expected) {
+		t.Error("Different result, expected", expected, "got", res)
+	}
+}
+
+// staticResolver resolves the networks from a map indexed by namespace/name.
+type staticResolver map[string]netattachdef.Metadata
+
+func (r staticResolver) Resolve(podNamespace, networkName string) (netattachdef.Metadata, bool) {
+	if !strings.Contains(networkName, "/") {
+		networkName = podNamespace + "/" + networkName
+	}
+	res, ok := r[networkName]
+	return res, ok
+}
+
+func TestNetworkResolver(t *testing.T) {
+	resolver := staticResolver{
+		"namespace1/macvlan": {PluginType: "macvlan", Labels: map[string]string{"team": "red"}},
+		"namespacename/sriov": {
+			PluginType:   "sriov",
+			ResourceName: "openshift.io/intelnics",
+			Labels:       map[string]string{"app.kubernetes.io/name": "sriov"},
+		},
+	}
+
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.PodLabelAllowlist = []string{"app"}
+	options.NADLabelAllowlist = []string{"team", "app.kubernetes.io/name"}
+	p.SetOptions(options)
+	p.SetNetworkResolver(resolver)
+	p.UpdateForPod("podname", "namespacename", "podname-uid", map[string]string{"app": "app1"}, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/macvlan"},
+		{Interface: "net2", NetworkName: "sriov"},
+	})
+
+	expected := `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="eth0",label_app="app1",nad_label_app_kubernetes_io_name="",nad_label_team="",namespace="namespacename",network_name="kindnet",plugin_type="",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net1",label_app="app1",nad_label_app_kubernetes_io_name="",nad_label_team="red",namespace="namespacename",network_name="namespace1/macvlan",plugin_type="macvlan",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net2",label_app="app1",nad_label_app_kubernetes_io_name="sriov",nad_label_team="",namespace="namespacename",network_name="sriov",plugin_type="sriov",pod="podname",resource_name="openshift.io/intelnics"} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	// the changes to the definitions are reflected on the next scrape
+	resolver["namespace1/macvlan"] = netattachdef.Metadata{PluginType: "ipvlan"}
+	delete(resolver, "namespacename/sriov")
+	expected = `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="eth0",label_app="app1",namespace="namespacename",network_name="kindnet",plugin_type="",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net1",label_app="app1",namespace="namespacename",network_name="namespace1/macvlan",plugin_type="ipvlan",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net2",label_app="app1",namespace="namespacename",network_name="sriov",plugin_type="",pod="podname",resource_name=""} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	// the labels not in the allowlist are not published
+	resolver["namespace1/macvlan"] = netattachdef.Metadata{PluginType: "macvlan", Labels: map[string]string{"team": "red", "owner": "someone"}}
+	options.NADLabelAllowlist = []string{"team"}
+	p.SetOptions(options)
+	expected = `
+	# HELP pod_network_name_info Metric to identify network names of networks added to pods.
+	# TYPE pod_network_name_info gauge
+	pod_network_name_info{interface="eth0",label_app="app1",nad_label_team="",namespace="namespacename",network_name="kindnet",plugin_type="",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net1",label_app="app1",nad_label_team="red",namespace="namespacename",network_name="namespace1/macvlan",plugin_type="macvlan",pod="podname",resource_name=""} 0
+	pod_network_name_info{interface="net2",label_app="app1",nad_label_team="",namespace="namespacename",network_name="sriov",plugin_type="",pod="podname",resource_name=""} 0
+	`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_name_info"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestAttachmentMissingMetrics(t *testing.T) {
+	p := podmetrics.New()
+	p.UpdateMissingForPod("podname1", "namespacename", "podname1-uid", []podnetwork.RequestedNetwork{
+		{NetworkName: "namespace1/macvlan", Interface: "net1"},
+		{NetworkName: "namespace1/macvlan", Interface: "net2"},
+		{NetworkName: "namespace1/sriov"},
+	})
+	p.UpdateMissingForPod("podname2", "namespacename", "podname2-uid", []podnetwork.RequestedNetwork{
+		{NetworkName: "namespace1/sriov"},
+	})
+	// the attachments already known as missing are not counted again
+	p.UpdateMissingForPod("podname2", "namespacename", "podname2-uid", []podnetwork.RequestedNetwork{
+		{NetworkName: "namespace1/sriov"},
+	})
+
+	expected := `
+	# HELP node_network_attachment_mismatches_total Number of requested network attachments found missing from the network status of the pods on the node.
+	# TYPE node_network_attachment_mismatches_total counter
+	node_network_attachment_mismatches_total 4
+	# HELP pod_network_attachment_missing Number of attachments to the network requested by the pod and missing from its network status.
+	# TYPE pod_network_attachment_missing gauge
+	pod_network_attachment_missing{namespace="namespacename",network_name="namespace1/macvlan",pod="podname1"} 2
+	pod_network_attachment_missing{namespace="namespacename",network_name="namespace1/sriov",pod="podname1"} 1
+	pod_network_attachment_missing{namespace="namespacename",network_name="namespace1/sriov",pod="podname2"} 1
+	`
+	err := testutil.CollectAndCompare(p, strings.NewReader(expected), "pod_network_attachment_missing", "node_network_attachment_mismatches_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	p.UpdateMissingForPod("podname1", "namespacename", "podname1-uid", nil)
+	p.DeleteAllForPod("podname2", "namespacename", "podname2-uid")
+	if count := testutil.CollectAndCount(p, "pod_network_attachment_missing"); count != 0 {
+		t.Error("Expected no missing attachments, got", count)
+	}
+}
+
+func TestNodeSummaryMetrics(t *testing.T) {
+	p := podmetrics.New()
+	options := podmetrics.DefaultOptions()
+	options.Labels.Deny = []podmetrics.FilterRule{
+		{Namespaces: []string{"denied"}},
+	}
+	p.SetOptions(options)
+
+	p.UpdateForPod("podname1", "namespacename", "podname1-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+		{Interface: "net2", NetworkName: "namespace1/firstNAD"},
+	})
+	p.UpdateForPod("podname2", "namespacename", "podname2-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+	})
+	p.UpdateForPod("podname3", "namespacename", "podname3-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+	})
+	p.UpdateForPod("podname4", "denied", "podname4-uid", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+	})
+	p.UpdateForPod("podname5", "namespacename", "podname5-uid", nil, []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "namespace1/secondNAD"},
+	})
+	p.DeleteAllForPod("podname5", "namespacename", "podname5-uid")
+	p.StatusParseFailed("uid")
+	p.StatusParseFailed("uid")
+
+	expected := `
+	# HELP node_network_attachments Number of pod attachments to secondary networks on the node, for each network.
+	# TYPE node_network_attachments gauge
+	node_network_attachments{network_name="namespace1/firstNAD"} 2
+	node_network_attachments{network_name="namespace1/secondNAD"} 1
+	# HELP node_network_status_parse_errors_total Number of failures parsing the network status annotation of the pods on the node.
+	# TYPE node_network_status_parse_errors_total counter
+	node_network_status_parse_errors_total 2
+	# HELP node_pods_with_secondary_networks Number of pods on the node attached to at least one secondary network.
+	# TYPE node_pods_with_secondary_networks gauge
+	node_pods_with_secondary_networks 2
+	`
+	err := testutil.CollectAndCompare(p, strings.NewReader(expected),
+		"node_network_attachments", "node_pods_with_secondary_networks", "node_network_status_parse_errors_total")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestDuration time.Duration
+		shutdownTimeout time.Duration
+		expectError     bool
+	}{
+		{"drains in flight requests", 200 * time.Millisecond, 5 * time.Second, false},
+		{"shutdown timeout exceeded", 5 * time.Second, 100 * time.Millisecond, true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			address := freeAddress(t)
+			started := make(chan struct{})
+			slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tc.requestDuration)
+				w.Write([]byte("done"))
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			serveErr := make(chan error, 1)
+			go func() {
+				serveErr <- podmetrics.Serve(ctx, podmetrics.ServerOptions{Address: address, ShutdownTimeout: tc.shutdownTimeout}, map[string]http.Handler{"/slow": slow}, podmetrics.New())
+			}()
+			waitForServer(t, address)
+
+			requestErr := make(chan error, 1)
+			go func() {
+				res, err := http.Get("http://" + address + "/slow")
+				if err == nil {
+					res.Body.Close()
+				}
+				requestErr <- err
+			}()
+			<-started
+			cancel()
+
+			err := <-serveErr
+			if tc.expectError && err == nil {
+				t.Error("Expected the shutdown to fail")
+			}
+			if !tc.expectError {
+				if err != nil {
+					t.Error("Expected a clean shutdown, got", err)
+				}
+				if err := <-requestErr; err != nil {
+					t.Error("Expected the in flight request to complete, got", err)
+				}
+			}
+		})
+	}
+}
+
+func TestServeListenFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen", err)
+	}
+	defer listener.Close()
+
+	err = podmetrics.Serve(context.Background(), podmetrics.ServerOptions{Address: listener.Addr().String(), ShutdownTimeout: time.Second}, nil, podmetrics.New())
+	if err == nil {
+		t.Error("Expected an error serving on an address in use")
+	}
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func waitForServer(t *testing.T, address string) {
+	for i := 0; i < 100; i++ {
+		res, err := http.Get("http://" + address + "/healthz")
+		if err == nil {
+			res.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Server not started on", address)
+}
+
+func TestServeAuthorize(t *testing.T) {
+	address := freeAddress(t)
+	denyAll := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := podmetrics.ServerOptions{Address: address, ShutdownTimeout: time.Second, Authorize: denyAll}
+	go podmetrics.Serve(ctx, options, nil, podmetrics.New())
+	waitForServer(t, address)
+
+	for path, expected := range map[string]int{"/metrics": http.StatusForbidden, "/healthz": http.StatusOK} {
+		res, err := http.Get("http://" + address + path)
+		if err != nil {
+			t.Fatal("Failed to get", path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("Expected status %d for %s, got %d", expected, path, res.StatusCode)
+		}
+	}
+}
+
+func TestCounterExemplars(t *testing.T) {
+	tests := []struct {
+		name    string
+		counter string
+		update  func(p *podmetrics.PodMetrics)
+	}{
+		{
+			"parse errors",
+			"node_network_status_parse_errors_total",
+			func(p *podmetrics.PodMetrics) {
+				p.StatusParseFailed("uid1")
+				p.StatusParseFailed("uid2")
+			},
+		},
+		{
+			"attachment mismatches",
+			"node_network_attachment_mismatches_total",
+			func(p *podmetrics.PodMetrics) {
+				missing := []podnetwork.RequestedNetwork{{NetworkName: "namespace1/sriov"}}
+				p.UpdateMissingForPod("podname1", "namespacename", "uid1", missing)
+				p.UpdateMissingForPod("podname2", "namespacename", "uid2", missing)
+				// the attachments already known as missing do not change the exemplar
+				p.UpdateMissingForPod("podname1", "namespacename", "uid1", missing)
+			},
+		},
+	}
+	for _, tst := range tests {
+		p := podmetrics.New()
+		tst.update(p)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(p)
+		families, err := registry.Gather()
 		if err != nil {
-			t.Error("Failed to collect metrics", tst.testName, err)
+			t.Fatal(tst.name, "Failed to gather metrics", err)
+		}
+		found := false
+		for _, family := range families {
+			if family.GetName() != tst.counter {
+				continue
+			}
+			found = true
+			counter := family.GetMetric()[0].GetCounter()
+			if counter.GetCreatedTimestamp() == nil {
+				t.Error(tst.name, "Expected the counter to have a created timestamp")
+			}
+			labels := counter.GetExemplar().GetLabel()
+			if len(labels) != 1 || labels[0].GetName() != "pod_uid" || labels[0].GetValue() != "uid2" {
+				t.Error(tst.name, "Expected the exemplar to carry the uid of the last pod, got", labels)
+			}
+		}
+		if !found {
+			t.Error(tst.name, "Counter not found")
 		}
-		podmetrics.NetAttachDefPerPod.Reset()
 	}
+}
 
+func TestServeDisableMetrics(t *testing.T) {
+	address := freeAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := podmetrics.ServerOptions{Address: address, ShutdownTimeout: time.Second, DisableMetrics: true}
+	go podmetrics.Serve(ctx, options, nil, podmetrics.New())
+	waitForServer(t, address)
+
+	res, err := http.Get("http://" + address + "/metrics")
+	if err != nil {
+		t.Fatal("Failed to get /metrics", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("Expected /metrics not to be served, got", res.StatusCode)
+	}
 }
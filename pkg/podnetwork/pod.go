@@ -1,7 +1,7 @@
 package podnetwork
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
@@ -11,8 +11,93 @@ import (
 const Status = "k8s.v1.cni.cncf.io/network-status"
 
 type status struct {
-	Name      string `json:"name"`
-	Interface string `json:"interface,omitempty"`
+	Name       string      `json:"name"`
+	Interface  string      `json:"interface,omitempty"`
+	IPs        []string    `json:"ips,omitempty"`
+	Mac        string      `json:"mac,omitempty"`
+	Default    bool        `json:"default,omitempty"`
+	DNS        *DNS        `json:"dns,omitempty"`
+	Gateway    []string    `json:"gateway,omitempty"`
+	DeviceInfo *DeviceInfo `json:"device-info,omitempty"`
+}
+
+// DNS contains the dns configuration reported for a given network.
+type DNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// DeviceInfo describes the device backing a given network attachment,
+// as defined by the device-info section of the network status annotation.
+type DeviceInfo struct {
+	Type      string           `json:"type"`
+	Version   string           `json:"version,omitempty"`
+	Pci       *PciDevice       `json:"pci,omitempty"`
+	Vdpa      *VdpaDevice      `json:"vdpa,omitempty"`
+	VhostUser *VhostUserDevice `json:"vhost-user,omitempty"`
+	Memif     *MemifDevice     `json:"memif,omitempty"`
+}
+
+// PciDevice holds the info related to a pci device.
+type PciDevice struct {
+	PciAddress        string `json:"pci-address,omitempty"`
+	Vhostnet          string `json:"vhost-net,omitempty"`
+	RdmaDevice        string `json:"rdma-device,omitempty"`
+	PfPciAddress      string `json:"pf-pci-address,omitempty"`
+	RepresentorDevice string `json:"representor-device,omitempty"`
+}
+
+// VdpaDevice holds the info related to a vdpa device.
+type VdpaDevice struct {
+	ParentDevice      string `json:"parent-device,omitempty"`
+	Driver            string `json:"driver,omitempty"`
+	Path              string `json:"path,omitempty"`
+	PciAddress        string `json:"pci-address,omitempty"`
+	PfPciAddress      string `json:"pf-pci-address,omitempty"`
+	RepresentorDevice string `json:"representor-device,omitempty"`
+}
+
+// VhostUserDevice holds the info related to a vhost-user socket.
+type VhostUserDevice struct {
+	Mode string `json:"mode,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// MemifDevice holds the info related to a memif socket.
+type MemifDevice struct {
+	Role string `json:"role,omitempty"`
+	Path string `json:"path,omitempty"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// PciAddress returns the pci address of the device backing the
+// attachment, if any.
+func (d *DeviceInfo) PciAddress() string {
+	switch {
+	case d == nil:
+		return ""
+	case d.Pci != nil:
+		return d.Pci.PciAddress
+	case d.Vdpa != nil:
+		return d.Vdpa.PciAddress
+	}
+	return ""
+}
+
+// PfPciAddress returns the pci address of the physical function
+// the device backing the attachment belongs to, if any.
+func (d *DeviceInfo) PfPciAddress() string {
+	switch {
+	case d == nil:
+		return ""
+	case d.Pci != nil:
+		return d.Pci.PfPciAddress
+	case d.Vdpa != nil:
+		return d.Vdpa.PfPciAddress
+	}
+	return ""
 }
 
 // Network represents the link between the pod,
@@ -20,25 +105,45 @@ type status struct {
 type Network struct {
 	Interface   string
 	NetworkName string
+	IPs         []string
+	Mac         string
+	Default     bool
+	DNS         DNS
+	Gateway     []string
+	DeviceInfo  *DeviceInfo
 }
 
-// Get return a slice of Networks info taken
-// from the network status annotation of the given pod.
-func Get(pod *corev1.Pod) ([]Network, error) {
-	annotation, ok := pod.GetAnnotations()[Status]
-	if !ok || annotation == "" {
-		return make([]Network, 0), nil
-	}
+// ParseError is returned when the network status annotation of a pod
+// is malformed. Retrying is pointless until the annotation changes.
+type ParseError struct {
+	Pod        string
+	Annotation string
+	Err        error
+}
 
-	var statuses []status
-	if err := json.Unmarshal([]byte(annotation), &statuses); err != nil {
-		return nil, fmt.Errorf("Failed to parse network status annotation for pod %s %v - [%s]", pod.Name, err, annotation)
-	}
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Failed to parse network status annotation for pod %s %v - [%s]", e.Pod, e.Err, e.Annotation)
+}
 
-	res := make([]Network, len(statuses))
-	for i, s := range statuses {
-		res[i].Interface = s.Interface
-		res[i].NetworkName = s.Name
-	}
-	return res, nil
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// IsParseError returns true if the given error is caused by a
+// malformed network status annotation.
+func IsParseError(err error) bool {
+	var parseErr *ParseError
+	return errors.As(err, &parseErr)
+}
+
+// Get return a slice of Networks info taken from the
+// network status sources of the given pod, see DefaultSources.
+func Get(pod *corev1.Pod) ([]Network, error) {
+	return DefaultSources().Get(pod)
+}
+
+// GetFrom return a slice of Networks info taken
+// from the given annotation of the given pod.
+func GetFrom(pod *corev1.Pod, statusAnnotation string) ([]Network, error) {
+	return SourcesFor(statusAnnotation).Get(pod)
 }
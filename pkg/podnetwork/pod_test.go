@@ -39,6 +39,46 @@ const multipleNetworkAnnotation = `[{
 	"dns": {}
 }]`
 
+const fullNetworkAnnotation = `[{
+	"name": "namespace1/sriov-net",
+	"interface": "net1",
+	"ips": [
+		"192.168.1.10",
+		"fd00::10"
+	],
+	"mac": "b2:07:4f:af:1c:a6",
+	"dns": {
+		"nameservers": ["10.0.0.1"],
+		"domain": "example.com",
+		"search": ["svc.example.com"]
+	},
+	"gateway": [
+		"192.168.1.1"
+	],
+	"device-info": {
+		"type": "pci",
+		"version": "1.1.0",
+		"pci": {
+			"pci-address": "0000:3b:02.4",
+			"pf-pci-address": "0000:3b:00.0"
+		}
+	}
+}]`
+
+const partialNetworkAnnotation = `[{
+	"name": "namespace1/macvlan-conf"
+},{
+	"name": "namespace1/vhost-net",
+	"interface": "net2",
+	"device-info": {
+		"type": "vhost-user",
+		"vhost-user": {
+			"mode": "server",
+			"path": "/var/run/vhost/net2.sock"
+		}
+	}
+}]`
+
 const noAnnotation = ""
 
 var podNetworkTests = []struct {
@@ -60,6 +100,9 @@ var podNetworkTests = []struct {
 			podnetwork.Network{
 				Interface:   "eth0",
 				NetworkName: "default/kindnet",
+				IPs:         []string{"10.244.0.10"},
+				Mac:         "4a:e9:0b:e2:63:67",
+				Default:     true,
 			},
 		},
 	},
@@ -77,10 +120,75 @@ var podNetworkTests = []struct {
 			podnetwork.Network{
 				Interface:   "eth0",
 				NetworkName: "default/kindnet",
+				IPs:         []string{"10.244.0.10"},
+				Mac:         "4a:e9:0b:e2:63:67",
+				Default:     true,
 			},
 			podnetwork.Network{
 				Interface:   "net1",
 				NetworkName: "namespace1/macvlan-conf",
+				IPs:         []string{"192.168.1.200"},
+				Mac:         "b2:07:4f:af:1c:a5",
+			},
+		},
+	},
+	{"allfields",
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "FullPodName",
+				Namespace: "FullPodNameSpace",
+				Annotations: map[string]string{
+					podnetwork.Status: fullNetworkAnnotation,
+				},
+			},
+		},
+		[]podnetwork.Network{
+			podnetwork.Network{
+				Interface:   "net1",
+				NetworkName: "namespace1/sriov-net",
+				IPs:         []string{"192.168.1.10", "fd00::10"},
+				Mac:         "b2:07:4f:af:1c:a6",
+				DNS: podnetwork.DNS{
+					Nameservers: []string{"10.0.0.1"},
+					Domain:      "example.com",
+					Search:      []string{"svc.example.com"},
+				},
+				Gateway: []string{"192.168.1.1"},
+				DeviceInfo: &podnetwork.DeviceInfo{
+					Type:    "pci",
+					Version: "1.1.0",
+					Pci: &podnetwork.PciDevice{
+						PciAddress:   "0000:3b:02.4",
+						PfPciAddress: "0000:3b:00.0",
+					},
+				},
+			},
+		},
+	},
+	{"partialentries",
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "PartialPodName",
+				Namespace: "PartialPodNameSpace",
+				Annotations: map[string]string{
+					podnetwork.Status: partialNetworkAnnotation,
+				},
+			},
+		},
+		[]podnetwork.Network{
+			podnetwork.Network{
+				NetworkName: "namespace1/macvlan-conf",
+			},
+			podnetwork.Network{
+				Interface:   "net2",
+				NetworkName: "namespace1/vhost-net",
+				DeviceInfo: &podnetwork.DeviceInfo{
+					Type: "vhost-user",
+					VhostUser: &podnetwork.VhostUserDevice{
+						Mode: "server",
+						Path: "/var/run/vhost/net2.sock",
+					},
+				},
 			},
 		},
 	},
@@ -115,3 +223,35 @@ func TestPodToNetwork(t *testing.T) {
 		}
 	}
 }
+
+var malformedNetworkTests = []struct {
+	testName   string
+	annotation string
+}{
+	{"notalist", `{"name": "default/kindnet", "interface": "eth0"}`},
+	{"truncated", `[{"name": "default/kindnet", "interface": "eth0"`},
+	{"ipsnotalist", `[{"name": "default/kindnet", "interface": "eth0", "ips": "10.244.0.10"}]`},
+	{"defaultnotabool", `[{"name": "default/kindnet", "interface": "eth0", "default": "true"}]`},
+	{"deviceinfonotanobject", `[{"name": "namespace1/sriov-net", "interface": "net1", "device-info": "pci"}]`},
+}
+
+func TestMalformedPodToNetwork(t *testing.T) {
+	for _, tst := range malformedNetworkTests {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "MalformedPodName",
+				Namespace: "MalformedPodNameSpace",
+				Annotations: map[string]string{
+					podnetwork.Status: tst.annotation,
+				},
+			},
+		}
+		networks, err := podnetwork.Get(pod)
+		if err == nil {
+			t.Error(tst.testName, "Expected error, got", networks)
+		}
+		if !podnetwork.IsParseError(err) {
+			t.Error(tst.testName, "Expected a parse error, got", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package podnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Networks is the name of the annotation the pods request
+// their additional networks with
+const Networks = "k8s.v1.cni.cncf.io/networks"
+
+// RequestedNetwork is a network requested by a pod, as found in the
+// network selection annotation.
+type RequestedNetwork struct {
+	// NetworkName is the namespaced name of the network attachment definition
+	NetworkName string
+	// Interface is the interface name requested for the attachment, if any
+	Interface string
+}
+
+// networkSelection is an element of the network selection
+// annotation in its JSON form.
+type networkSelection struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// GetRequested returns the networks requested by the given pod, either as
+// a comma separated list of [namespace/]name[@interface] or as a JSON list.
+// The network names are qualified with the namespace of the pod when needed.
+func GetRequested(pod *corev1.Pod) ([]RequestedNetwork, error) {
+	annotation := strings.TrimSpace(pod.GetAnnotations()[Networks])
+	if annotation == "" {
+		return nil, nil
+	}
+
+	var selections []networkSelection
+	if strings.HasPrefix(annotation, "[") {
+		if err := json.Unmarshal([]byte(annotation), &selections); err != nil {
+			return nil, fmt.Errorf("failed to parse network selection annotation for pod %s: %v", pod.Name, err)
+		}
+	} else {
+		for _, item := range strings.Split(annotation, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			var s networkSelection
+			if i := strings.LastIndex(item, "@"); i >= 0 {
+				item, s.Interface = item[:i], item[i+1:]
+			}
+			if i := strings.Index(item, "/"); i >= 0 {
+				s.Namespace, item = item[:i], item[i+1:]
+			}
+			s.Name = item
+			selections = append(selections, s)
+		}
+	}
+
+	res := make([]RequestedNetwork, 0, len(selections))
+	for _, s := range selections {
+		if s.Name == "" {
+			return nil, fmt.Errorf("invalid network selection annotation for pod %s: missing network name", pod.Name)
+		}
+		namespace := s.Namespace
+		if namespace == "" {
+			namespace = pod.Namespace
+		}
+		res = append(res, RequestedNetwork{
+			NetworkName: namespace + "/" + s.Name,
+			Interface:   s.Interface,
+		})
+	}
+	return res, nil
+}
+
+// Missing returns the requested networks not found among the given networks.
+// A requested network is found when a network with the same name, and the
+// same interface if one was requested, is reported. Each reported network
+// accounts for a single request.
+func Missing(requested []RequestedNetwork, networks []Network) []RequestedNetwork {
+	used := make([]bool, len(networks))
+	var res []RequestedNetwork
+	for _, r := range requested {
+		found := false
+		for i, n := range networks {
+			if used[i] || n.NetworkName != r.NetworkName {
+				continue
+			}
+			if r.Interface != "" && n.Interface != r.Interface {
+				continue
+			}
+			used[i] = true
+			found = true
+			break
+		}
+		if !found {
+			res = append(res, r)
+		}
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package podnetwork_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRequestingPod(annotation string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "PodName",
+			Namespace: "PodNamespace",
+			Annotations: map[string]string{
+				podnetwork.Networks: annotation,
+			},
+		},
+	}
+}
+
+var requestedTests = []struct {
+	testName   string
+	annotation string
+	res        []podnetwork.RequestedNetwork
+}{
+	{"empty",
+		"",
+		nil,
+	},
+	{"commalist",
+		"macvlan-net, namespace1/sriov-net@net2,bridge-net@br1",
+		[]podnetwork.RequestedNetwork{
+			{NetworkName: "PodNamespace/macvlan-net"},
+			{NetworkName: "namespace1/sriov-net", Interface: "net2"},
+			{NetworkName: "PodNamespace/bridge-net", Interface: "br1"},
+		},
+	},
+	{"json",
+		`[{"name": "macvlan-net"}, {"name": "sriov-net", "namespace": "namespace1", "interface": "net2", "mac": "c2:b0:57:49:47:f1"}]`,
+		[]podnetwork.RequestedNetwork{
+			{NetworkName: "PodNamespace/macvlan-net"},
+			{NetworkName: "namespace1/sriov-net", Interface: "net2"},
+		},
+	},
+}
+
+func TestGetRequested(t *testing.T) {
+	for _, tst := range requestedTests {
+		res, err := podnetwork.GetRequested(newRequestingPod(tst.annotation))
+		if err != nil {
+			t.Error(tst.testName, "Unexpected error", err)
+			continue
+		}
+		if !reflect.DeepEqual(res, tst.res) {
+			t.Error(tst.testName, "Different result, expected", tst.res, "got", res)
+		}
+	}
+}
+
+func TestMalformedRequested(t *testing.T) {
+	for _, annotation := range []string{`[{"name": "macvlan-net"`, `[{"namespace": "namespace1"}]`, "namespace1/@net1"} {
+		if res, err := podnetwork.GetRequested(newRequestingPod(annotation)); err == nil {
+			t.Error("Expected error for", annotation, "got", res)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	requested := []podnetwork.RequestedNetwork{
+		{NetworkName: "ns1/macvlan-net"},
+		{NetworkName: "ns1/macvlan-net"},
+		{NetworkName: "ns1/sriov-net", Interface: "net3"},
+		{NetworkName: "ns1/bridge-net", Interface: "br1"},
+	}
+	networks := []podnetwork.Network{
+		{Interface: "eth0", NetworkName: "kindnet", Default: true},
+		{Interface: "net1", NetworkName: "ns1/macvlan-net"},
+		{Interface: "net2", NetworkName: "ns1/sriov-net"},
+		{Interface: "br1", NetworkName: "ns1/bridge-net"},
+	}
+	expected := []podnetwork.RequestedNetwork{
+		{NetworkName: "ns1/macvlan-net"},
+		{NetworkName: "ns1/sriov-net", Interface: "net3"},
+	}
+	if res := podnetwork.Missing(requested, networks); !reflect.DeepEqual(res, expected) {
+		t.Error("Expected", expected, "got", res)
+	}
+	if res := podnetwork.Missing(requested[:1], networks); len(res) != 0 {
+		t.Error("Expected no missing networks, got", res)
+	}
+}
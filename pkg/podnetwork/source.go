@@ -0,0 +1,251 @@
+package podnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LegacyStatus is the name of the deprecated network status annotation,
+// still written by older versions of multus
+const LegacyStatus = "k8s.v1.cni.cncf.io/networks-status"
+
+// OVNPodNetworks is the name of the annotation OVN-Kubernetes
+// describes the networks of a pod with
+const OVNPodNetworks = "k8s.ovn.org/pod-networks"
+
+// ovnDefaultNetwork is the key of the cluster default network
+// in the OVN-Kubernetes pod networks annotation
+const ovnDefaultNetwork = "default"
+
+// ovnDefaultInterface is the interface the cluster default
+// network is attached to by OVN-Kubernetes
+const ovnDefaultInterface = "eth0"
+
+// Source reads the network status of a pod from an annotation.
+type Source interface {
+	// Annotation returns the name of the annotation the status is read from.
+	Annotation() string
+	// Parse returns the networks described by the given annotation value.
+	Parse(value string) ([]Network, error)
+}
+
+// NewSource returns the source reading the given annotation, parsed
+// according to the format of the CNI stack writing it. Unknown
+// annotations are expected to follow the network status format.
+func NewSource(annotation string) Source {
+	if annotation == OVNPodNetworks {
+		return ovnSource{}
+	}
+	return networkStatusSource(annotation)
+}
+
+// networkStatusSource reads an annotation in the network status format
+// defined by the network plumbing working group.
+type networkStatusSource string
+
+func (s networkStatusSource) Annotation() string {
+	return string(s)
+}
+
+func (s networkStatusSource) Parse(value string) ([]Network, error) {
+	var statuses []status
+	if err := json.Unmarshal([]byte(value), &statuses); err != nil {
+		return nil, err
+	}
+
+	res := make([]Network, len(statuses))
+	for i, s := range statuses {
+		res[i].Interface = s.Interface
+		res[i].NetworkName = s.Name
+		res[i].IPs = s.IPs
+		res[i].Mac = s.Mac
+		res[i].Default = s.Default
+		if s.DNS != nil {
+			res[i].DNS = *s.DNS
+		}
+		res[i].Gateway = s.Gateway
+		res[i].DeviceInfo = s.DeviceInfo
+	}
+	return res, nil
+}
+
+type ovnPodNetwork struct {
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+	IPAddress   string   `json:"ip_address,omitempty"`
+	MacAddress  string   `json:"mac_address,omitempty"`
+	GatewayIPs  []string `json:"gateway_ips,omitempty"`
+	GatewayIP   string   `json:"gateway_ip,omitempty"`
+	Role        string   `json:"role,omitempty"`
+}
+
+// ovnSource reads the OVN-Kubernetes pod networks annotation, a map of
+// the networks keyed by "default" or by the namespaced name of the network
+// attachment definition. The annotation does not carry the interface
+// names, so only the one of the default network is known here, the ones
+// of the secondary networks being derived from the network selection
+// annotation of the pod by Sources.
+type ovnSource struct{}
+
+func (ovnSource) Annotation() string {
+	return OVNPodNetworks
+}
+
+func (ovnSource) Parse(value string) ([]Network, error) {
+	var networks map[string]ovnPodNetwork
+	if err := json.Unmarshal([]byte(value), &networks); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]Network, 0, len(networks))
+	for _, name := range names {
+		n := networks[name]
+		network := Network{
+			NetworkName: name,
+			Mac:         n.MacAddress,
+			Default:     name == ovnDefaultNetwork || n.Role == "primary",
+		}
+		if name == ovnDefaultNetwork {
+			network.Interface = ovnDefaultInterface
+		}
+
+		ips := n.IPAddresses
+		if len(ips) == 0 && n.IPAddress != "" {
+			ips = []string{n.IPAddress}
+		}
+		for _, ip := range ips {
+			network.IPs = append(network.IPs, stripPrefix(ip))
+		}
+		network.Gateway = n.GatewayIPs
+		if len(network.Gateway) == 0 && n.GatewayIP != "" {
+			network.Gateway = []string{n.GatewayIP}
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+// stripPrefix returns the address of the given CIDR, or the
+// given string as is if it is not a CIDR.
+func stripPrefix(cidr string) string {
+	if !strings.Contains(cidr, "/") {
+		return cidr
+	}
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return ip.String()
+}
+
+// Sources reads the network status from the first source, in order of
+// precedence, whose annotation is set on the pod.
+type Sources []Source
+
+// DefaultSources returns the sources of the known CNI stacks, in order of
+// precedence: the network status annotation, the legacy networks status
+// annotation and the OVN-Kubernetes pod networks annotation.
+func DefaultSources() Sources {
+	return SourcesFor(Status, LegacyStatus, OVNPodNetworks)
+}
+
+// SourcesFor returns the sources reading the given annotations,
+// in order of precedence.
+func SourcesFor(annotations ...string) Sources {
+	res := make(Sources, 0, len(annotations))
+	for _, a := range annotations {
+		res = append(res, NewSource(a))
+	}
+	return res
+}
+
+// Lookup returns the source the network status of the given pod is
+// read from, together with the value of its annotation.
+func (s Sources) Lookup(pod *corev1.Pod) (Source, string, bool) {
+	for _, source := range s {
+		value, ok := pod.GetAnnotations()[source.Annotation()]
+		if ok && value != "" {
+			return source, value, true
+		}
+	}
+	return nil, "", false
+}
+
+// Has returns true if any of the annotations is set on the given pod.
+func (s Sources) Has(pod *corev1.Pod) bool {
+	for _, source := range s {
+		if _, ok := pod.GetAnnotations()[source.Annotation()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed returns true if any of the annotations differs
+// between the given pods.
+func (s Sources) Changed(oldPod, newPod *corev1.Pod) bool {
+	for _, source := range s {
+		a := source.Annotation()
+		if oldPod.GetAnnotations()[a] != newPod.GetAnnotations()[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// Get return a slice of Networks info taken from the
+// first source whose annotation is set on the given pod.
+func (s Sources) Get(pod *corev1.Pod) ([]Network, error) {
+	source, value, ok := s.Lookup(pod)
+	if !ok {
+		return make([]Network, 0), nil
+	}
+	res, err := source.Parse(value)
+	if err != nil {
+		return nil, &ParseError{Pod: pod.Name, Annotation: value, Err: err}
+	}
+	if _, ok := source.(ovnSource); ok {
+		// a malformed selection leaves the interfaces unknown, the missing
+		// attachments are reported separately
+		if requested, err := GetRequested(pod); err == nil {
+			res = withRequestedInterfaces(res, requested)
+		}
+	}
+	return res, nil
+}
+
+// withRequestedInterfaces sets the interfaces of the networks lacking one to
+// the interfaces the networks were requested with, defaulting to the net<n>
+// names multus gives to the attachments by their position in the selection.
+// Each request names at most one network, and the networks requested more
+// than once are matched in order.
+func withRequestedInterfaces(networks []Network, requested []RequestedNetwork) []Network {
+	used := make([]bool, len(requested))
+	for i := range networks {
+		if networks[i].Interface != "" {
+			continue
+		}
+		for j, r := range requested {
+			if used[j] || r.NetworkName != networks[i].NetworkName {
+				continue
+			}
+			used[j] = true
+			networks[i].Interface = r.Interface
+			if networks[i].Interface == "" {
+				networks[i].Interface = fmt.Sprintf("net%d", j+1)
+			}
+			break
+		}
+	}
+	return networks
+}
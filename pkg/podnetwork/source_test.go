@@ -0,0 +1,228 @@
+package podnetwork_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ovnPodNetworksAnnotation = `{
+	"default": {
+		"ip_addresses": ["10.128.0.12/23", "fd01:0:0:1::c/64"],
+		"mac_address": "0a:58:0a:80:00:0c",
+		"gateway_ips": ["10.128.0.1"],
+		"ip_address": "10.128.0.12/23",
+		"gateway_ip": "10.128.0.1"
+	},
+	"namespace1/l2-net": {
+		"ip_address": "192.168.10.5/24",
+		"mac_address": "0a:58:c0:a8:0a:05",
+		"role": "secondary"
+	}
+}`
+
+const legacyNetworkAnnotation = `[{
+	"name": "default/legacy",
+	"interface": "eth0"
+}]`
+
+func newAnnotatedPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "PodName",
+			Namespace:   "PodNamespace",
+			Annotations: annotations,
+		},
+	}
+}
+
+var sourceTests = []struct {
+	testName    string
+	annotations map[string]string
+	res         []podnetwork.Network
+}{
+	{"networkstatus",
+		map[string]string{
+			podnetwork.Status: simpleNetworkAnnotation,
+		},
+		[]podnetwork.Network{
+			{Interface: "eth0", NetworkName: "default/kindnet", IPs: []string{"10.244.0.10"}, Mac: "4a:e9:0b:e2:63:67", Default: true},
+		},
+	},
+	{"legacy",
+		map[string]string{
+			podnetwork.LegacyStatus: legacyNetworkAnnotation,
+		},
+		[]podnetwork.Network{
+			{Interface: "eth0", NetworkName: "default/legacy"},
+		},
+	},
+	{"networkstatusoverlegacy",
+		map[string]string{
+			podnetwork.Status:       simpleNetworkAnnotation,
+			podnetwork.LegacyStatus: legacyNetworkAnnotation,
+		},
+		[]podnetwork.Network{
+			{Interface: "eth0", NetworkName: "default/kindnet", IPs: []string{"10.244.0.10"}, Mac: "4a:e9:0b:e2:63:67", Default: true},
+		},
+	},
+	{"emptynetworkstatusfallsback",
+		map[string]string{
+			podnetwork.Status:       "",
+			podnetwork.LegacyStatus: legacyNetworkAnnotation,
+		},
+		[]podnetwork.Network{
+			{Interface: "eth0", NetworkName: "default/legacy"},
+		},
+	},
+	{"legacyoverovn",
+		map[string]string{
+			podnetwork.LegacyStatus:   legacyNetworkAnnotation,
+			podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+		},
+		[]podnetwork.Network{
+			{Interface: "eth0", NetworkName: "default/legacy"},
+		},
+	},
+	{"ovn",
+		map[string]string{
+			podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+		},
+		[]podnetwork.Network{
+			{
+				Interface:   "eth0",
+				NetworkName: "default",
+				IPs:         []string{"10.128.0.12", "fd01:0:0:1::c"},
+				Mac:         "0a:58:0a:80:00:0c",
+				Default:     true,
+				Gateway:     []string{"10.128.0.1"},
+			},
+			{
+				NetworkName: "namespace1/l2-net",
+				IPs:         []string{"192.168.10.5"},
+				Mac:         "0a:58:c0:a8:0a:05",
+			},
+		},
+	},
+	{"ovnsecondary",
+		map[string]string{
+			podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+			podnetwork.Networks:       "namespace1/other,namespace1/l2-net",
+		},
+		[]podnetwork.Network{
+			{
+				Interface:   "eth0",
+				NetworkName: "default",
+				IPs:         []string{"10.128.0.12", "fd01:0:0:1::c"},
+				Mac:         "0a:58:0a:80:00:0c",
+				Default:     true,
+				Gateway:     []string{"10.128.0.1"},
+			},
+			{
+				Interface:   "net2",
+				NetworkName: "namespace1/l2-net",
+				IPs:         []string{"192.168.10.5"},
+				Mac:         "0a:58:c0:a8:0a:05",
+			},
+		},
+	},
+	{"ovnsecondaryrequestedinterface",
+		map[string]string{
+			podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+			podnetwork.Networks:       `[{"name": "l2-net", "namespace": "namespace1", "interface": "l2"}]`,
+		},
+		[]podnetwork.Network{
+			{
+				Interface:   "eth0",
+				NetworkName: "default",
+				IPs:         []string{"10.128.0.12", "fd01:0:0:1::c"},
+				Mac:         "0a:58:0a:80:00:0c",
+				Default:     true,
+				Gateway:     []string{"10.128.0.1"},
+			},
+			{
+				Interface:   "l2",
+				NetworkName: "namespace1/l2-net",
+				IPs:         []string{"192.168.10.5"},
+				Mac:         "0a:58:c0:a8:0a:05",
+			},
+		},
+	},
+	{"ovnsecondarynotrequested",
+		map[string]string{
+			podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+			podnetwork.Networks:       "namespace2/l2-net",
+		},
+		[]podnetwork.Network{
+			{
+				Interface:   "eth0",
+				NetworkName: "default",
+				IPs:         []string{"10.128.0.12", "fd01:0:0:1::c"},
+				Mac:         "0a:58:0a:80:00:0c",
+				Default:     true,
+				Gateway:     []string{"10.128.0.1"},
+			},
+			{
+				NetworkName: "namespace1/l2-net",
+				IPs:         []string{"192.168.10.5"},
+				Mac:         "0a:58:c0:a8:0a:05",
+			},
+		},
+	},
+	{"none",
+		map[string]string{},
+		[]podnetwork.Network{},
+	},
+}
+
+func TestDefaultSources(t *testing.T) {
+	for _, tst := range sourceTests {
+		pod := newAnnotatedPod(tst.annotations)
+		networks, err := podnetwork.DefaultSources().Get(pod)
+		if err != nil {
+			t.Error(tst.testName, "Unexpected error", err)
+			continue
+		}
+		if !reflect.DeepEqual(networks, tst.res) {
+			t.Error(tst.testName, "Different result, expected", tst.res, "got", networks)
+		}
+	}
+}
+
+func TestSourcesFor(t *testing.T) {
+	pod := newAnnotatedPod(map[string]string{
+		podnetwork.Status:         simpleNetworkAnnotation,
+		podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation,
+	})
+	sources := podnetwork.SourcesFor(podnetwork.OVNPodNetworks, podnetwork.Status)
+	source, value, ok := sources.Lookup(pod)
+	if !ok || source.Annotation() != podnetwork.OVNPodNetworks || value != ovnPodNetworksAnnotation {
+		t.Error("Expected the OVN annotation to take precedence, got", source, value, ok)
+	}
+
+	if sources := podnetwork.SourcesFor(podnetwork.LegacyStatus); sources.Has(pod) {
+		t.Error("Expected the legacy annotation not to be found")
+	}
+}
+
+func TestSourcesChanged(t *testing.T) {
+	oldPod := newAnnotatedPod(map[string]string{podnetwork.OVNPodNetworks: ovnPodNetworksAnnotation})
+	newPod := newAnnotatedPod(map[string]string{podnetwork.OVNPodNetworks: "{}", "other": "value"})
+	if !podnetwork.DefaultSources().Changed(oldPod, newPod) {
+		t.Error("Expected the OVN annotation change to be detected")
+	}
+	if podnetwork.SourcesFor(podnetwork.Status).Changed(oldPod, newPod) {
+		t.Error("Expected no change in the network status annotation")
+	}
+}
+
+func TestMalformedOVNPodNetworks(t *testing.T) {
+	pod := newAnnotatedPod(map[string]string{podnetwork.OVNPodNetworks: `[{"name": "default"}]`})
+	networks, err := podnetwork.DefaultSources().Get(pod)
+	if !podnetwork.IsParseError(err) {
+		t.Error("Expected a parse error, got", networks, err)
+	}
+}
@@ -0,0 +1,271 @@
+package podstats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+var labelNames = []string{"pod", "namespace", "interface", "network_name"}
+
+// counter describes one of the published counters.
+type counter struct {
+	name  string
+	help  string
+	value func(InterfaceStats) uint64
+}
+
+var counters = []counter{
+	{"pod_network_receive_bytes_total",
+		"Cumulative count of bytes received by the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.RxBytes }},
+	{"pod_network_receive_packets_total",
+		"Cumulative count of packets received by the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.RxPackets }},
+	{"pod_network_receive_errors_total",
+		"Cumulative count of errors encountered while receiving on the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.RxErrors }},
+	{"pod_network_receive_packets_dropped_total",
+		"Cumulative count of packets dropped while receiving on the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.RxDropped }},
+	{"pod_network_transmit_bytes_total",
+		"Cumulative count of bytes transmitted by the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.TxBytes }},
+	{"pod_network_transmit_packets_total",
+		"Cumulative count of packets transmitted by the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.TxPackets }},
+	{"pod_network_transmit_errors_total",
+		"Cumulative count of errors encountered while transmitting on the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.TxErrors }},
+	{"pod_network_transmit_packets_dropped_total",
+		"Cumulative count of packets dropped while transmitting on the networks added to pods.",
+		func(s InterfaceStats) uint64 { return s.TxDropped }},
+}
+
+const (
+	interfaceUp             = "pod_network_interface_up"
+	interfaceUpHelp         = "Whether the networks added to pods are operationally up."
+	interfaceOperState      = "pod_network_interface_operstate_info"
+	interfaceOperStateHelp  = "RFC 2863 operational state of the networks added to pods."
+	interfaceMTU            = "pod_network_interface_mtu_bytes"
+	interfaceMTUHelp        = "MTU of the networks added to pods."
+	interfaceCarrierChanges = "pod_network_interface_carrier_changes_total"
+	interfaceCarrierHelp    = "Number of times the carrier of the networks added to pods went up or down."
+	interfaceSpeed          = "pod_network_interface_speed_bytes"
+	interfaceSpeedHelp      = "Negotiated speed of the networks added to pods, in bytes per second."
+)
+
+// resolveRetryInterval is how long to wait before looking again for the
+// network namespace of a pod it was not found for, to avoid scanning all
+// the processes of the node on each scrape.
+const resolveRetryInterval = time.Minute
+
+var operStateLabelNames = append(append([]string{}, labelNames...), "operstate")
+
+// PublishesLabel returns true if the given label name is published by the
+// collector.
+func PublishesLabel(name string) bool {
+	for _, n := range operStateLabelNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+type podKey struct {
+	name      string
+	namespace string
+}
+
+type podEntry struct {
+	uid      types.UID
+	nsPath   string
+	networks []podnetwork.Network
+	// resolveErr is the error of the last failed lookup of the network
+	// namespace, done at resolveFailedAt
+	resolveErr      error
+	resolveFailedAt time.Time
+}
+
+// Collector publishes the counters and the state of the interfaces of the
+// pods running on the node, reading them from within the pods' network
+// namespace. Enabling it enables the counters, and the pods keep being
+// tracked while it is disabled.
+type Collector struct {
+	podmetrics.OptionalCollector
+	resolver     NetNSResolver
+	mtx          sync.Mutex
+	pods         map[podKey]*podEntry
+	stateEnabled bool
+	now          func() time.Time
+}
+
+// NewCollector returns a new collector using the given resolver to find the
+// pods' network namespaces, with the counters enabled and the state disabled.
+func NewCollector(resolver NetNSResolver) *Collector {
+	c := &Collector{
+		resolver: resolver,
+		pods:     make(map[podKey]*podEntry),
+		now:      time.Now,
+	}
+	c.SetEnabled(true)
+	return c
+}
+
+// SetStateEnabled enables or disables the collection of the state
+// of the interfaces, i.e. whether they are up and their MTU.
+func (c *Collector) SetStateEnabled(enabled bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.stateEnabled = enabled
+}
+
+// UpdateForPod starts collecting the counters of the given networks of the pod.
+func (c *Collector) UpdateForPod(podName, namespace string, uid types.UID, networks []podnetwork.Network) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := podKey{podName, namespace}
+	entry, ok := c.pods[key]
+	if !ok || entry.uid != uid {
+		entry = &podEntry{uid: uid}
+		c.pods[key] = entry
+	}
+	entry.networks = networks
+	// the namespace might be there now
+	entry.resolveErr = nil
+}
+
+// DeleteForPod stops collecting the counters of the given pod, unless
+// it was replaced by a new one with the same name but a different uid.
+func (c *Collector) DeleteForPod(podName, namespace string, uid types.UID) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := podKey{podName, namespace}
+	if entry, ok := c.pods[key]; ok && entry.uid == uid {
+		delete(c.pods, key)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	enabled := c.Enabled()
+	c.mtx.Lock()
+	stateEnabled := c.stateEnabled
+	if !enabled && !stateEnabled {
+		c.mtx.Unlock()
+		return
+	}
+	type snapshot struct {
+		key   podKey
+		entry podEntry
+	}
+	pods := make([]snapshot, 0, len(c.pods))
+	for k, e := range c.pods {
+		pods = append(pods, snapshot{k, *e})
+	}
+	c.mtx.Unlock()
+	emitter := c.NewEmitter(ch)
+
+	for _, p := range pods {
+		var stats map[string]InterfaceStats
+		var states map[string]LinkState
+		err := c.readFromPod(p.key, p.entry, func(nsPath string) error {
+			var err error
+			if enabled {
+				if stats, err = ReadInterfaceStats(nsPath); err != nil {
+					return err
+				}
+			}
+			if stateEnabled {
+				if states, err = ReadLinkStates(nsPath); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			klog.Warningf("Failed to read the interfaces of pod %s/%s: %v", p.key.namespace, p.key.name, err)
+			continue
+		}
+		for _, n := range p.entry.networks {
+			if n.Interface == "" {
+				continue
+			}
+			values := []string{p.key.name, p.key.namespace, n.Interface, n.NetworkName}
+			if s, ok := stats[n.Interface]; ok {
+				for _, counter := range counters {
+					emitter.Emit(counter.name, counter.help, prometheus.CounterValue, float64(counter.value(s)),
+						labelNames, values...)
+				}
+			}
+			if s, ok := states[n.Interface]; ok {
+				emitState(emitter, s, values)
+			}
+		}
+	}
+}
+
+// emitState publishes the state of the interface with the given label values.
+func emitState(emitter *podmetrics.Emitter, s LinkState, values []string) {
+	up := 0.0
+	if s.Up {
+		up = 1
+	}
+	emitter.Emit(interfaceUp, interfaceUpHelp, prometheus.GaugeValue, up, labelNames, values...)
+	emitter.Emit(interfaceOperState, interfaceOperStateHelp, prometheus.GaugeValue, 0,
+		operStateLabelNames, append(append([]string{}, values...), s.OperState)...)
+	emitter.Emit(interfaceMTU, interfaceMTUHelp, prometheus.GaugeValue, float64(s.MTU), labelNames, values...)
+	emitter.Emit(interfaceCarrierChanges, interfaceCarrierHelp, prometheus.CounterValue, float64(s.CarrierChanges), labelNames, values...)
+	if s.SpeedBytes > 0 {
+		emitter.Emit(interfaceSpeed, interfaceSpeedHelp, prometheus.GaugeValue, float64(s.SpeedBytes), labelNames, values...)
+	}
+}
+
+// readFromPod calls read with the network namespace of the given pod,
+// resolving it if not known yet or if the cached one is not valid anymore.
+// The lookups failed recently are not retried.
+func (c *Collector) readFromPod(key podKey, entry podEntry, read func(nsPath string) error) error {
+	if entry.nsPath != "" && c.resolver.Owns(entry.uid, entry.nsPath) {
+		if err := read(entry.nsPath); err == nil {
+			return nil
+		}
+	}
+	if entry.resolveErr != nil && c.now().Sub(entry.resolveFailedAt) < resolveRetryInterval {
+		return entry.resolveErr
+	}
+
+	nsPath, err := c.resolver.NetNSPath(entry.uid)
+	if err != nil {
+		c.updateEntry(key, entry.uid, func(e *podEntry) {
+			e.nsPath = ""
+			e.resolveErr = err
+			e.resolveFailedAt = c.now()
+		})
+		return err
+	}
+	if err := read(nsPath); err != nil {
+		return err
+	}
+
+	c.updateEntry(key, entry.uid, func(e *podEntry) {
+		e.nsPath = nsPath
+		e.resolveErr = nil
+	})
+	return nil
+}
+
+// updateEntry calls update with the entry of the given pod, unless it was
+// deleted or replaced by a new one with a different uid in the meanwhile.
+func (c *Collector) updateEntry(key podKey, uid types.UID, update func(*podEntry)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.pods[key]; ok && e.uid == uid {
+		update(e)
+	}
+}
@@ -0,0 +1,201 @@
+package podstats_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/openshift/network-metrics-daemon/pkg/podstats"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeResolver map[types.UID]string
+
+func (f fakeResolver) NetNSPath(uid types.UID) (string, error) {
+	path, ok := f[uid]
+	if !ok {
+		return "", fmt.Errorf("pod %s not found", uid)
+	}
+	return path, nil
+}
+
+func (f fakeResolver) Owns(uid types.UID, nsPath string) bool {
+	return f[uid] == nsPath
+}
+
+// countingResolver counts the lookups of the network namespaces.
+type countingResolver struct {
+	fakeResolver
+	lookups int
+}
+
+func (r *countingResolver) NetNSPath(uid types.UID) (string, error) {
+	r.lookups++
+	return r.fakeResolver.NetNSPath(uid)
+}
+
+// setupNetNS creates a network namespace with a veth pair, one end of
+// which is moved into the namespace and renamed to the given interface name.
+func setupNetNS(t *testing.T, name, iface string) string {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip command not available")
+	}
+
+	run := func(args ...string) {
+		out, err := exec.Command("ip", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("ip %s failed: %v %s", strings.Join(args, " "), err, out)
+		}
+	}
+	hostVeth := name + "-h"
+	run("netns", "add", name)
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", hostVeth).Run()
+		exec.Command("ip", "netns", "del", name).Run()
+	})
+	run("link", "add", hostVeth, "type", "veth", "peer", "name", name+"-p")
+	run("link", "set", name+"-p", "netns", name)
+	run("-n", name, "link", "set", name+"-p", "name", iface)
+	run("-n", name, "link", "set", iface, "up")
+	run("link", "set", hostVeth, "up")
+
+	return "/var/run/netns/" + name
+}
+
+func TestReadInterfaceStats(t *testing.T) {
+	nsPath := setupNetNS(t, "nmd-read", "net1")
+
+	stats, err := podstats.ReadInterfaceStats(nsPath)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, ok := stats["net1"]; !ok {
+		t.Error("net1 not found in", stats)
+	}
+	if _, ok := stats["lo"]; !ok {
+		t.Error("lo not found in", stats)
+	}
+	if _, ok := stats["nmd-read-h"]; ok {
+		t.Error("host interface found in the pod namespace", stats)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	nsPath := setupNetNS(t, "nmd-collect", "net1")
+
+	resolver := fakeResolver{"uid1": nsPath}
+	collector := podstats.NewCollector(resolver)
+	collector.UpdateForPod("podname", "namespacename", "uid1", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+		{Interface: "net2", NetworkName: "namespace1/missing"},
+		{NetworkName: "namespace1/nointerface"},
+	})
+	collector.UpdateForPod("podname2", "namespacename", "notresolvable", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+
+	// one counter per direction and per kind for net1 only
+	if count := testutil.CollectAndCount(collector); count != 8 {
+		t.Error("Expected 8 metrics, got", count)
+	}
+
+	expected := `
+	# HELP pod_network_receive_errors_total Cumulative count of errors encountered while receiving on the networks added to pods.
+	# TYPE pod_network_receive_errors_total counter
+	pod_network_receive_errors_total{interface="net1",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "pod_network_receive_errors_total"); err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+
+	collector.DeleteForPod("podname", "namespacename", "uid1")
+	if count := testutil.CollectAndCount(collector); count != 0 {
+		t.Error("Expected no metrics after delete, got", count)
+	}
+}
+
+func TestCollectorState(t *testing.T) {
+	nsPath := setupNetNS(t, "nmd-state", "net1")
+
+	collector := podstats.NewCollector(fakeResolver{"uid1": nsPath})
+	collector.SetEnabled(false)
+	collector.SetStateEnabled(true)
+	collector.UpdateForPod("podname", "namespacename", "uid1", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+
+	// veth reports a speed of 10Gbps
+	expected := `
+	# HELP pod_network_interface_mtu_bytes MTU of the networks added to pods.
+	# TYPE pod_network_interface_mtu_bytes gauge
+	pod_network_interface_mtu_bytes{interface="net1",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 1500
+	# HELP pod_network_interface_speed_bytes Negotiated speed of the networks added to pods, in bytes per second.
+	# TYPE pod_network_interface_speed_bytes gauge
+	pod_network_interface_speed_bytes{interface="net1",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 1.25e+09
+	# HELP pod_network_interface_operstate_info RFC 2863 operational state of the networks added to pods.
+	# TYPE pod_network_interface_operstate_info gauge
+	pod_network_interface_operstate_info{interface="net1",namespace="namespacename",network_name="namespace1/firstNAD",operstate="up",pod="podname"} 0
+	# HELP pod_network_interface_up Whether the networks added to pods are operationally up.
+	# TYPE pod_network_interface_up gauge
+	pod_network_interface_up{interface="net1",namespace="namespacename",network_name="namespace1/firstNAD",pod="podname"} 1
+	`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"pod_network_interface_up", "pod_network_interface_operstate_info", "pod_network_interface_mtu_bytes", "pod_network_interface_speed_bytes")
+	if err != nil {
+		t.Error("Failed to collect metrics", err)
+	}
+	if count := testutil.CollectAndCount(collector, "pod_network_interface_carrier_changes_total"); count != 1 {
+		t.Error("Expected the carrier changes, got", count)
+	}
+	if count := testutil.CollectAndCount(collector, "pod_network_receive_bytes_total"); count != 0 {
+		t.Error("Expected no counters when disabled, got", count)
+	}
+}
+
+func TestCollectorResolvesOnce(t *testing.T) {
+	nsPath := setupNetNS(t, "nmd-resolve", "net1")
+
+	resolver := &countingResolver{fakeResolver: fakeResolver{"uid1": nsPath}}
+	collector := podstats.NewCollector(resolver)
+	collector.UpdateForPod("podname", "namespacename", "uid1", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	collector.UpdateForPod("podname2", "namespacename", "notresolvable", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+
+	for i := 0; i < 3; i++ {
+		if count := testutil.CollectAndCount(collector); count != 8 {
+			t.Error("Expected 8 metrics, got", count)
+		}
+	}
+	// the namespace found is cached, and the failed lookup is not retried
+	if resolver.lookups != 2 {
+		t.Error("Expected 2 lookups, got", resolver.lookups)
+	}
+
+	// the namespace does not belong to the pod anymore
+	delete(resolver.fakeResolver, "uid1")
+	if count := testutil.CollectAndCount(collector); count != 0 {
+		t.Error("Expected no metrics, got", count)
+	}
+	if resolver.lookups != 3 {
+		t.Error("Expected the namespace to be looked up again, got", resolver.lookups)
+	}
+
+	// an update of the pod triggers a new lookup
+	collector.UpdateForPod("podname2", "namespacename", "notresolvable", []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	testutil.CollectAndCount(collector)
+	if resolver.lookups != 4 {
+		t.Error("Expected the updated pod to be looked up again, got", resolver.lookups)
+	}
+}
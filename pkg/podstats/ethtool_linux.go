@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package podstats
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// speedUnknown is reported by the drivers not knowing the speed of the link
+const speedUnknown = 0xffffffff
+
+// ethtoolCmd is struct ethtool_cmd, from linux/ethtool.h
+type ethtoolCmd struct {
+	cmd           uint32
+	supported     uint32
+	advertising   uint32
+	speed         uint16
+	duplex        uint8
+	port          uint8
+	phyAddress    uint8
+	transceiver   uint8
+	autoneg       uint8
+	mdioSupport   uint8
+	maxtxpkt      uint32
+	maxrxpkt      uint32
+	speedHi       uint16
+	ethTpMdix     uint8
+	ethTpMdixCtrl uint8
+	lpAdvertising uint32
+	reserved      [2]uint32
+}
+
+// ifreqData is a struct ifreq carrying a pointer, padded
+// to be at least as large as the one of the kernel.
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24]byte
+}
+
+// linkSpeed returns the speed in megabits per second of the given link,
+// as reported by ethtool via the given socket. False is returned if the
+// speed is not known, i.e. for the links not supporting ethtool.
+func linkSpeed(fd int, name string) (uint32, bool) {
+	cmd := ethtoolCmd{cmd: unix.ETHTOOL_GSET}
+	var ifr ifreqData
+	copy(ifr.name[:unix.IFNAMSIZ-1], name)
+	ifr.data = unsafe.Pointer(&cmd)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(&cmd)
+	if errno != 0 {
+		return 0, false
+	}
+	speed := uint32(cmd.speedHi)<<16 | uint32(cmd.speed)
+	if speed == 0 || speed == speedUnknown {
+		return 0, false
+	}
+	return speed, true
+}
@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package podstats
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+	"golang.org/x/sys/unix"
+)
+
+// ReadInterfaceStats enters the network namespace pointed by nsPath and
+// returns the counters of all the interfaces found there, indexed by
+// interface name.
+func ReadInterfaceStats(nsPath string) (map[string]InterfaceStats, error) {
+	var res map[string]InterfaceStats
+	err := InNetNS(nsPath, func() error {
+		f, err := os.Open("/proc/thread-self/net/dev")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err = parseNetDev(f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadLinkStates enters the network namespace pointed by nsPath and returns
+// the state of all the interfaces found there, indexed by interface name.
+func ReadLinkStates(nsPath string) (map[string]LinkState, error) {
+	var res map[string]LinkState
+	err := InNetNS(nsPath, func() error {
+		links, err := rtnl.ListLinks()
+		if err != nil {
+			return err
+		}
+		// the socket must be created within the namespace
+		// to query the speed of its links
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open ethtool socket: %v", err)
+		}
+		defer unix.Close(fd)
+
+		res = make(map[string]LinkState, len(links))
+		for _, l := range links {
+			state := LinkState{
+				Up:             l.Up(),
+				OperState:      l.OperStateName(),
+				MTU:            l.MTU,
+				CarrierChanges: l.CarrierChanges,
+			}
+			if speed, ok := linkSpeed(fd, l.Name); ok {
+				// the speed is reported in megabits per second
+				state.SpeedBytes = uint64(speed) * 1000 * 1000 / 8
+			}
+			res[l.Name] = state
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// InNetNS runs the given function with the calling thread switched
+// to the network namespace pointed by nsPath. The thread is moved back
+// to its original namespace before returning.
+func InNetNS(nsPath string, toRun func() error) error {
+	target, err := os.Open(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %v", nsPath, err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+
+	current, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to open current netns: %v", err)
+	}
+	defer current.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter netns %s: %v", nsPath, err)
+	}
+
+	runErr := toRun()
+
+	if err := unix.Setns(int(current.Fd()), unix.CLONE_NEWNET); err != nil {
+		// the thread is left locked on purpose so that the runtime
+		// terminates it instead of reusing it in the wrong namespace
+		return fmt.Errorf("failed to restore netns: %v", err)
+	}
+	runtime.UnlockOSThread()
+	return runErr
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package podstats
+
+import "fmt"
+
+// ReadInterfaceStats is supported on linux only.
+func ReadInterfaceStats(nsPath string) (map[string]InterfaceStats, error) {
+	return nil, fmt.Errorf("reading interface stats is not supported on this platform")
+}
+
+// ReadLinkStates is supported on linux only.
+func ReadLinkStates(nsPath string) (map[string]LinkState, error) {
+	return nil, fmt.Errorf("reading link states is not supported on this platform")
+}
+
+// InNetNS is supported on linux only.
+func InNetNS(nsPath string, toRun func() error) error {
+	return fmt.Errorf("switching network namespace is not supported on this platform")
+}
@@ -0,0 +1,79 @@
+package podstats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// NetNSResolver returns the path of the network namespace of a given pod.
+type NetNSResolver interface {
+	NetNSPath(uid types.UID) (string, error)
+	// Owns returns true if the given path, previously returned for the pod,
+	// still refers to its network namespace.
+	Owns(uid types.UID, nsPath string) bool
+}
+
+// ProcResolver finds the network namespace of a pod by looking for a
+// process belonging to the pod's cgroup under the given proc root. Unlike
+// the sandbox path known to the container runtime, the namespace of a pod
+// is not found once all its processes have exited.
+type ProcResolver struct {
+	ProcRoot string
+}
+
+// NewProcResolver returns a resolver scanning the given proc filesystem.
+func NewProcResolver(procRoot string) *ProcResolver {
+	return &ProcResolver{ProcRoot: procRoot}
+}
+
+// NetNSPath returns the /proc/<pid>/ns/net path of the first process found
+// running in the pod with the given uid.
+func (r *ProcResolver) NetNSPath(uid types.UID) (string, error) {
+	entries, err := os.ReadDir(r.ProcRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", r.ProcRoot, err)
+	}
+
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		pidDir := filepath.Join(r.ProcRoot, e.Name())
+		if inPodCgroup(pidDir, uid) {
+			return filepath.Join(pidDir, "ns", "net"), nil
+		}
+	}
+	return "", fmt.Errorf("no process found for pod %s", uid)
+}
+
+// Owns returns true if the process of the given /proc/<pid>/ns/net path is
+// still running in the pod with the given uid, as its pid might have been
+// reused by a process of another pod.
+func (r *ProcResolver) Owns(uid types.UID, nsPath string) bool {
+	return inPodCgroup(filepath.Dir(filepath.Dir(nsPath)), uid)
+}
+
+// inPodCgroup returns true if the process of the given /proc/<pid> directory
+// belongs to the cgroup of the pod with the given uid.
+func inPodCgroup(pidDir string, uid types.UID) bool {
+	cgroup, err := os.ReadFile(filepath.Join(pidDir, "cgroup"))
+	if err != nil {
+		// the process might have exited in the meanwhile
+		return false
+	}
+	// the systemd cgroup driver replaces the dashes of the uid with underscores
+	for _, id := range []string{
+		"pod" + string(uid),
+		"pod" + strings.ReplaceAll(string(uid), "-", "_"),
+	} {
+		if strings.Contains(string(cgroup), id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+//go:build linux && privileged
+// +build linux,privileged
+
+package podstats_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/openshift/network-metrics-daemon/pkg/podstats"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const privilegedPodUID = types.UID("aaaabbbb-0000-1111-2222-333344445555")
+
+// startPodProcess starts a process in the given network namespace, moved to a
+// cgroup named after the pod with the given uid as the kubelet does, and
+// returns it. The cgroup is created on the first hierarchy found.
+func startPodProcess(t *testing.T, netns string, uid types.UID) *exec.Cmd {
+	var cgroupDir string
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified", "/sys/fs/cgroup/pids"} {
+		if _, err := os.Stat(filepath.Join(root, "cgroup.procs")); err == nil {
+			cgroupDir = filepath.Join(root, "nmd-test", "pod"+string(uid))
+			break
+		}
+	}
+	if cgroupDir == "" {
+		t.Skip("No cgroup hierarchy found")
+	}
+	if err := os.MkdirAll(cgroupDir, 0755); err != nil {
+		t.Fatal("Failed to create the cgroup", err)
+	}
+
+	// ip netns exec execs the command, keeping the pid
+	cmd := exec.Command("ip", "netns", "exec", netns, "sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal("Failed to start the process", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.Remove(cgroupDir)
+		os.Remove(filepath.Dir(cgroupDir))
+	})
+	if err := os.WriteFile(filepath.Join(cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal("Failed to move the process to the cgroup", err)
+	}
+	return cmd
+}
+
+func TestProcResolverNetNS(t *testing.T) {
+	nsPath := setupNetNS(t, "nmd-resolver", "net1")
+	cmd := startPodProcess(t, "nmd-resolver", privilegedPodUID)
+
+	resolver := podstats.NewProcResolver("/proc")
+	var resolved string
+	var err error
+	// the process might not have entered the namespace yet
+	for i := 0; i < 100; i++ {
+		resolved, err = resolver.NetNSPath(privilegedPodUID)
+		if err == nil && sameFile(resolved, nsPath) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !sameFile(resolved, nsPath) {
+		t.Fatal("Expected", resolved, "to be the namespace", nsPath)
+	}
+	if !resolver.Owns(privilegedPodUID, resolved) {
+		t.Error("Expected the pod to own", resolved)
+	}
+
+	collector := podstats.NewCollector(resolver)
+	collector.UpdateForPod("podname", "namespacename", privilegedPodUID, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	if count := testutil.CollectAndCount(collector, "pod_network_receive_bytes_total"); count != 1 {
+		t.Error("Expected the counters of net1, got", count)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if resolver.Owns(privilegedPodUID, resolved) {
+		t.Error("Expected the exited process not to be owned by the pod")
+	}
+}
+
+// sameFile returns true if the given paths refer to the same file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
@@ -0,0 +1,85 @@
+package podstats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestProcResolver(t *testing.T) {
+	procRoot := t.TempDir()
+	processes := map[string]string{
+		"1":    "0::/init.scope\n",
+		"1234": "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1b2c3d4e_0000_1111_2222_333344445555.slice/crio-abc.scope\n",
+		"5678": "12:memory:/kubepods/burstable/podaaaabbbb-0000-1111-2222-333344445555/def\n",
+		"self": "0::/kubepods/burstable/podaaaabbbb-0000-1111-2222-333344445555/def\n",
+	}
+	for pid, cgroup := range processes {
+		if err := os.MkdirAll(filepath.Join(procRoot, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procRoot, pid, "cgroup"), []byte(cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolver := NewProcResolver(procRoot)
+	tests := []struct {
+		uid      string
+		expected string
+	}{
+		{"1b2c3d4e-0000-1111-2222-333344445555", filepath.Join(procRoot, "1234", "ns", "net")},
+		{"aaaabbbb-0000-1111-2222-333344445555", filepath.Join(procRoot, "5678", "ns", "net")},
+	}
+	for _, tst := range tests {
+		path, err := resolver.NetNSPath(types.UID(tst.uid))
+		if err != nil {
+			t.Error(tst.uid, "Unexpected error", err)
+			continue
+		}
+		if path != tst.expected {
+			t.Error(tst.uid, "expected", tst.expected, "got", path)
+		}
+	}
+
+	if _, err := resolver.NetNSPath("notexisting"); err == nil {
+		t.Error("Expected error for non existing pod")
+	}
+}
+
+func TestProcResolverOwns(t *testing.T) {
+	procRoot := t.TempDir()
+	writeCgroup := func(pid, cgroup string) {
+		if err := os.MkdirAll(filepath.Join(procRoot, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procRoot, pid, "cgroup"), []byte(cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCgroup("1234", "0::/kubepods/burstable/podaaaabbbb-0000-1111-2222-333344445555/def\n")
+
+	resolver := NewProcResolver(procRoot)
+	nsPath, err := resolver.NetNSPath("aaaabbbb-0000-1111-2222-333344445555")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !resolver.Owns("aaaabbbb-0000-1111-2222-333344445555", nsPath) {
+		t.Error("Expected the pod to own", nsPath)
+	}
+
+	// the pid is reused by a process of another pod
+	writeCgroup("1234", "0::/kubepods/burstable/podccccdddd-0000-1111-2222-333344445555/def\n")
+	if resolver.Owns("aaaabbbb-0000-1111-2222-333344445555", nsPath) {
+		t.Error("Expected the reused pid not to be owned by the pod")
+	}
+
+	if err := os.RemoveAll(filepath.Join(procRoot, "1234")); err != nil {
+		t.Fatal(err)
+	}
+	if resolver.Owns("ccccdddd-0000-1111-2222-333344445555", nsPath) {
+		t.Error("Expected the exited process not to be owned by any pod")
+	}
+}
@@ -0,0 +1,78 @@
+package podstats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InterfaceStats contains the counters of a network interface, as
+// reported by the kernel.
+type InterfaceStats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// LinkState contains the state of a network interface, as reported
+// by the kernel.
+type LinkState struct {
+	Up bool
+	// OperState is the RFC 2863 operational state, i.e. up or down
+	OperState      string
+	MTU            uint32
+	CarrierChanges uint32
+	// SpeedBytes is the negotiated speed in bytes per second,
+	// 0 if not known
+	SpeedBytes uint64
+}
+
+// parseNetDev parses the content of /proc/net/dev and returns the
+// counters of each interface, indexed by interface name.
+func parseNetDev(r io.Reader) (map[string]InterfaceStats, error) {
+	res := make(map[string]InterfaceStats)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 0; scanner.Scan(); lineNum++ {
+		// the first two lines are headers
+		if lineNum < 2 {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid net dev line %q", scanner.Text())
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("invalid net dev line %q, expected 16 fields got %d", scanner.Text(), len(fields))
+		}
+		values := make([]uint64, 16)
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid net dev value %q: %v", fields[i], err)
+			}
+			values[i] = v
+		}
+		res[strings.TrimSpace(parts[0])] = InterfaceStats{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
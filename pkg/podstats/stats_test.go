@@ -0,0 +1,42 @@
+package podstats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const netDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 4635894    4012    1    2    0     0          0         0   287456    2201    3    4    0     0       0          0
+  net1:     500       5    0    0    0     0          0         0      600       6    0    0    0     0       0          0
+`
+
+func TestParseNetDev(t *testing.T) {
+	stats, err := parseNetDev(strings.NewReader(netDev))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := map[string]InterfaceStats{
+		"lo":   {RxBytes: 1000, RxPackets: 10, TxBytes: 1000, TxPackets: 10},
+		"eth0": {RxBytes: 4635894, RxPackets: 4012, RxErrors: 1, RxDropped: 2, TxBytes: 287456, TxPackets: 2201, TxErrors: 3, TxDropped: 4},
+		"net1": {RxBytes: 500, RxPackets: 5, TxBytes: 600, TxPackets: 6},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Error("Different result, expected", expected, "got", stats)
+	}
+}
+
+func TestParseMalformedNetDev(t *testing.T) {
+	malformed := []string{
+		"header\nheader\n  eth0 4635894 4012\n",
+		"header\nheader\n  eth0: 4635894 4012 1 2\n",
+		"header\nheader\n  eth0: 4635894 4012 1 2 0 0 0 0 287456 2201 3 4 0 0 0 notanumber\n",
+	}
+	for _, m := range malformed {
+		if _, err := parseNetDev(strings.NewReader(m)); err == nil {
+			t.Error("Expected error parsing", m)
+		}
+	}
+}
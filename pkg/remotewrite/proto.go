@@ -0,0 +1,72 @@
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The remote write protocol messages, as defined in
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto.
+// They are encoded by hand as only a handful of fields are needed.
+
+// Label is a label of a series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// TimeSeries is a series with a single sample, as sent by the exporter.
+type TimeSeries struct {
+	// Labels are sorted by name, and include __name__
+	Labels []Label
+	Value  float64
+	// Timestamp is in milliseconds since the epoch
+	Timestamp int64
+}
+
+// field numbers of the remote write messages
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// marshalWriteRequest returns the protobuf encoding of a WriteRequest
+// containing the given series.
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTimeSeries(s))
+	}
+	return b
+}
+
+func marshalTimeSeries(s TimeSeries) []byte {
+	var b []byte
+	for _, l := range s.Labels {
+		var label []byte
+		label = protowire.AppendTag(label, labelName, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+
+		b = protowire.AppendTag(b, timeSeriesLabels, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+	sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+
+	b = protowire.AppendTag(b, timeSeriesSamples, protowire.BytesType)
+	b = protowire.AppendBytes(b, sample)
+	return b
+}
@@ -0,0 +1,318 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog"
+)
+
+// Options controls how the metrics are pushed.
+type Options struct {
+	// URL is the remote write endpoint
+	URL string
+	// Interval is how often the metrics are snapshotted
+	Interval time.Duration
+	// Timeout is the timeout of each request
+	Timeout time.Duration
+	// QueueSize is the maximum number of snapshots waiting to be sent.
+	// When full, the oldest snapshot is dropped.
+	QueueSize int
+	// MinBackoff and MaxBackoff bound the time waited between retries
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// ExternalLabels are added to all the series, unless already set
+	ExternalLabels map[string]string
+	// BearerTokenFile, if set, is read on each request and sent as bearer token
+	BearerTokenFile string
+}
+
+// DefaultOptions returns the default options, with no URL.
+func DefaultOptions() Options {
+	return Options{
+		Interval:   30 * time.Second,
+		Timeout:    10 * time.Second,
+		QueueSize:  10,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// staleNaN is the value marking a series as stale, the same as Prometheus
+// uses when a series disappears from a scrape.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// Exporter periodically snapshots the metrics collected by a gatherer, and
+// pushes them to a remote write endpoint. The snapshots are queued in memory,
+// so that they are not lost while the endpoint is not reachable.
+type Exporter struct {
+	options  Options
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    *queue
+}
+
+// New returns an exporter pushing the metrics collected by the given gatherer.
+func New(options Options, gatherer prometheus.Gatherer) (*Exporter, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("remote write url not set")
+	}
+	if options.Interval <= 0 || options.QueueSize <= 0 || options.MinBackoff <= 0 || options.MaxBackoff < options.MinBackoff {
+		return nil, fmt.Errorf("invalid remote write options %+v", options)
+	}
+	return &Exporter{
+		options:  options,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: options.Timeout},
+		queue:    newQueue(options.QueueSize),
+	}, nil
+}
+
+// Run snapshots and pushes the metrics until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.send(ctx)
+	}()
+
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		e.snapshot(time.Now())
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot queues the current value of the metrics.
+func (e *Exporter) snapshot(now time.Time) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		klog.Warningf("Error gathering metrics for remote write: %v", err)
+	}
+	// empty snapshots are queued too, so that the series
+	// pushed before are marked as stale
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	series := toTimeSeries(families, e.options.ExternalLabels, timestamp)
+	if dropped := e.queue.push(snapshot{timestamp, series}); dropped {
+		klog.Warning("Remote write queue full, dropping the oldest snapshot")
+	}
+}
+
+// send pushes the queued snapshots in order, retrying each of them until it
+// is either sent or rejected as invalid. The series of the last snapshot
+// sent that are missing from the current one are pushed as stale.
+func (e *Exporter) send(ctx context.Context) {
+	var pushed map[string][]Label
+	for {
+		snap, ok := e.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		series := withStaleMarkers(snap, pushed)
+		if len(series) == 0 {
+			continue
+		}
+		body := snappy.Encode(nil, marshalWriteRequest(series))
+
+		backoff := e.options.MinBackoff
+		for {
+			retry, err := e.post(ctx, body)
+			if err == nil {
+				pushed = seriesLabels(snap.series)
+				break
+			}
+			if !retry {
+				klog.Errorf("Dropping %d series rejected by the remote write endpoint: %v", len(series), err)
+				break
+			}
+			klog.Warningf("Failed to push %d series, retrying in %s: %v", len(series), backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > e.options.MaxBackoff {
+				backoff = e.options.MaxBackoff
+			}
+		}
+	}
+}
+
+// post sends the given encoded write request, returning whether
+// the request can be retried in case of failure.
+func (e *Exporter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "network-metrics-daemon")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.options.BearerTokenFile != "" {
+		token, err := os.ReadFile(e.options.BearerTokenFile)
+		if err != nil {
+			return true, fmt.Errorf("failed to read bearer token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		io.Copy(io.Discard, res.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	err = fmt.Errorf("server returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	// as for Prometheus, only server errors and throttling are retried
+	return res.StatusCode/100 == 5 || res.StatusCode == http.StatusTooManyRequests, err
+}
+
+// toTimeSeries converts the given metric families to series with the given
+// timestamp. Only counters, gauges and untyped metrics are converted.
+func toTimeSeries(families []*dto.MetricFamily, externalLabels map[string]string, timestamp int64) []TimeSeries {
+	var res []TimeSeries
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var value float64
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			labels := []Label{{Name: "__name__", Value: family.GetName()}}
+			set := make(map[string]bool, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels = append(labels, Label{Name: l.GetName(), Value: l.GetValue()})
+				set[l.GetName()] = true
+			}
+			for name, value := range externalLabels {
+				if !set[name] {
+					labels = append(labels, Label{Name: name, Value: value})
+				}
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+			res = append(res, TimeSeries{Labels: labels, Value: value, Timestamp: timestamp})
+		}
+	}
+	return res
+}
+
+// withStaleMarkers returns the series of the given snapshot, plus a stale
+// sample for each of the given series missing from it.
+func withStaleMarkers(snap snapshot, previous map[string][]Label) []TimeSeries {
+	res := snap.series
+	current := seriesLabels(snap.series)
+	for key, labels := range previous {
+		if _, ok := current[key]; !ok {
+			res = append(res, TimeSeries{Labels: labels, Value: staleNaN, Timestamp: snap.timestamp})
+		}
+	}
+	return res
+}
+
+// seriesLabels returns the labels of the given series, by series key.
+func seriesLabels(series []TimeSeries) map[string][]Label {
+	res := make(map[string][]Label, len(series))
+	for _, s := range series {
+		var key strings.Builder
+		for _, l := range s.Labels {
+			key.WriteString(l.Name)
+			key.WriteByte(0xff)
+			key.WriteString(l.Value)
+			key.WriteByte(0xff)
+		}
+		res[key.String()] = s.Labels
+	}
+	return res
+}
+
+// snapshot is the value of the metrics at a given time.
+type snapshot struct {
+	// timestamp is in milliseconds since the epoch
+	timestamp int64
+	series    []TimeSeries
+}
+
+// queue is a bounded fifo of snapshots, dropping the oldest
+// snapshot when full.
+type queue struct {
+	mtx       sync.Mutex
+	snapshots []snapshot
+	size      int
+	notify    chan struct{}
+}
+
+func newQueue(size int) *queue {
+	return &queue{size: size, notify: make(chan struct{}, 1)}
+}
+
+// push adds the given snapshot, returning true if the oldest one was dropped.
+func (q *queue) push(snap snapshot) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	dropped := false
+	if len(q.snapshots) == q.size {
+		q.snapshots = q.snapshots[1:]
+		dropped = true
+	}
+	q.snapshots = append(q.snapshots, snap)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// pop returns the oldest snapshot, waiting for one to be available.
+// It returns false if ctx is cancelled.
+func (q *queue) pop(ctx context.Context) (snapshot, bool) {
+	for {
+		q.mtx.Lock()
+		if len(q.snapshots) > 0 {
+			snap := q.snapshots[0]
+			q.snapshots = q.snapshots[1:]
+			q.mtx.Unlock()
+			return snap, true
+		}
+		q.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return snapshot{}, false
+		case <-q.notify:
+		}
+	}
+}
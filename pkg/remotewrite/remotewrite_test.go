@@ -0,0 +1,373 @@
+package remotewrite_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/openshift/network-metrics-daemon/pkg/podmetrics"
+	"github.com/openshift/network-metrics-daemon/pkg/podnetwork"
+	"github.com/openshift/network-metrics-daemon/pkg/remotewrite"
+)
+
+// receiver records the write requests, replying with the given status codes in
+// order, and with 204 once they are exhausted.
+type receiver struct {
+	mtx      sync.Mutex
+	t        *testing.T
+	statuses []int
+	requests [][]remotewrite.TimeSeries
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Error("Failed to read request", err)
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		r.t.Error("Failed to decode request", err)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.requests = append(r.requests, decodeWriteRequest(r.t, decoded))
+	r.headers = append(r.headers, req.Header)
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() ([][]remotewrite.TimeSeries, []http.Header) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([][]remotewrite.TimeSeries{}, r.requests...), append([]http.Header{}, r.headers...)
+}
+
+func (r *receiver) waitFor(t *testing.T, count int) [][]remotewrite.TimeSeries {
+	for i := 0; i < 200; i++ {
+		if requests, _ := r.received(); len(requests) >= count {
+			return requests
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected at least", count, "requests")
+	return nil
+}
+
+// decodeWriteRequest decodes the series of a WriteRequest, with the fields
+// sent by the exporter.
+func decodeWriteRequest(t *testing.T, b []byte) []remotewrite.TimeSeries {
+	var res []remotewrite.TimeSeries
+	forEachField(t, b, func(num protowire.Number, value []byte, _ uint64) {
+		var s remotewrite.TimeSeries
+		forEachField(t, value, func(num protowire.Number, value []byte, _ uint64) {
+			switch num {
+			case 1:
+				var l remotewrite.Label
+				forEachField(t, value, func(num protowire.Number, value []byte, _ uint64) {
+					if num == 1 {
+						l.Name = string(value)
+					} else {
+						l.Value = string(value)
+					}
+				})
+				s.Labels = append(s.Labels, l)
+			case 2:
+				forEachField(t, value, func(num protowire.Number, _ []byte, n uint64) {
+					if num == 1 {
+						s.Value = math.Float64frombits(n)
+					} else {
+						s.Timestamp = int64(n)
+					}
+				})
+			}
+		})
+		res = append(res, s)
+	})
+	return res
+}
+
+func forEachField(t *testing.T, b []byte, f func(num protowire.Number, value []byte, n uint64)) {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatal("Invalid tag", protowire.ParseError(l))
+		}
+		b = b[l:]
+		switch typ {
+		case protowire.BytesType:
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatal("Invalid bytes", protowire.ParseError(l))
+			}
+			f(num, v, 0)
+			b = b[l:]
+		case protowire.Fixed64Type:
+			v, l := protowire.ConsumeFixed64(b)
+			if l < 0 {
+				t.Fatal("Invalid fixed64", protowire.ParseError(l))
+			}
+			f(num, nil, v)
+			b = b[l:]
+		case protowire.VarintType:
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				t.Fatal("Invalid varint", protowire.ParseError(l))
+			}
+			f(num, nil, v)
+			b = b[l:]
+		default:
+			t.Fatal("Unexpected wire type", typ)
+		}
+	}
+}
+
+func newGatherer() prometheus.Gatherer {
+	p := podmetrics.New()
+	p.UpdateForPod("podname", "namespacename", "podname-uid", nil, []podnetwork.Network{
+		{Interface: "net1", NetworkName: "namespace1/firstNAD"},
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(p)
+	return registry
+}
+
+func newOptions(url string) remotewrite.Options {
+	options := remotewrite.DefaultOptions()
+	options.URL = url
+	options.Interval = time.Hour
+	options.MinBackoff = 10 * time.Millisecond
+	options.MaxBackoff = 20 * time.Millisecond
+	return options
+}
+
+func runExporter(t *testing.T, options remotewrite.Options, gatherer prometheus.Gatherer) context.CancelFunc {
+	exporter, err := remotewrite.New(options, gatherer)
+	if err != nil {
+		t.Fatal("Failed to create exporter", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := exporter.Run(ctx); err != nil {
+			t.Error("Unexpected error running the exporter", err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func findSeries(series []remotewrite.TimeSeries, name string) *remotewrite.TimeSeries {
+	for i, s := range series {
+		for _, l := range s.Labels {
+			if l.Name == "__name__" && l.Value == name {
+				return &series[i]
+			}
+		}
+	}
+	return nil
+}
+
+func TestPushesMetrics(t *testing.T) {
+	r := &receiver{t: t}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	options := newOptions(server.URL)
+	options.ExternalLabels = map[string]string{"instance": "node1", "pod": "external"}
+	stop := runExporter(t, options, newGatherer())
+	requests := r.waitFor(t, 1)
+	stop()
+
+	_, headers := r.received()
+	for name, expected := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if value := headers[0].Get(name); value != expected {
+			t.Errorf("Expected header %s to be %q, got %q", name, expected, value)
+		}
+	}
+
+	s := findSeries(requests[0], "pod_network_name_info")
+	if s == nil {
+		t.Fatal("Expected pod_network_name_info to be pushed, got", requests[0])
+	}
+	expected := []remotewrite.Label{
+		{Name: "__name__", Value: "pod_network_name_info"},
+		{Name: "instance", Value: "node1"},
+		{Name: "interface", Value: "net1"},
+		{Name: "namespace", Value: "namespacename"},
+		{Name: "network_name", Value: "namespace1/firstNAD"},
+		{Name: "pod", Value: "podname"},
+	}
+	if !reflect.DeepEqual(s.Labels, expected) {
+		t.Errorf("Expected labels %v, got %v", expected, s.Labels)
+	}
+	if s.Value != 0 || time.Since(time.Unix(0, s.Timestamp*int64(time.Millisecond))) > time.Minute {
+		t.Errorf("Unexpected sample %v %v", s.Value, s.Timestamp)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name             string
+		statuses         []int
+		expectedRequests int
+	}{
+		{"server errors are retried", []int{http.StatusInternalServerError, http.StatusServiceUnavailable}, 3},
+		{"throttling is retried", []int{http.StatusTooManyRequests}, 2},
+		{"bad requests are not retried", []int{http.StatusBadRequest, http.StatusBadRequest}, 1},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := &receiver{t: t, statuses: tc.statuses}
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			stop := runExporter(t, newOptions(server.URL), newGatherer())
+			r.waitFor(t, tc.expectedRequests)
+			// leave the time for unexpected retries
+			time.Sleep(100 * time.Millisecond)
+			stop()
+
+			requests, _ := r.received()
+			if len(requests) != tc.expectedRequests {
+				t.Fatalf("Expected %d requests, got %d", tc.expectedRequests, len(requests))
+			}
+			for _, req := range requests[1:] {
+				if !reflect.DeepEqual(req, requests[0]) {
+					t.Error("Expected the retries to send the same series")
+				}
+			}
+		})
+	}
+}
+
+func TestQueuesWhileUnavailable(t *testing.T) {
+	var mtx sync.Mutex
+	available := false
+	r := &receiver{t: t}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	options := newOptions(server.URL)
+	options.Interval = 20 * time.Millisecond
+	options.QueueSize = 3
+	stop := runExporter(t, options, newGatherer())
+	defer stop()
+
+	// let more snapshots than the queue size pile up
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	available = true
+	mtx.Unlock()
+
+	// the snapshot being retried and the queued ones are sent
+	requests := r.waitFor(t, options.QueueSize+1)
+	for i := 1; i < len(requests); i++ {
+		if requests[i][0].Timestamp < requests[i-1][0].Timestamp {
+			t.Error("Expected the snapshots to be sent in order")
+		}
+	}
+	// the first snapshot sent is the one being retried, the following
+	// ones are the most recent, as the oldest were dropped
+	interval := int64(options.Interval / time.Millisecond)
+	if gap := requests[1][0].Timestamp - requests[0][0].Timestamp; gap < int64(options.QueueSize)*interval {
+		t.Error("Expected the oldest snapshots to be dropped, gap", gap)
+	}
+	if gap := requests[2][0].Timestamp - requests[1][0].Timestamp; gap > 3*interval {
+		t.Error("Expected the queued snapshots to be consecutive, gap", gap)
+	}
+}
+
+func TestStaleMarkers(t *testing.T) {
+	r := &receiver{t: t}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge."}, []string{"name"})
+	gauge.WithLabelValues("kept").Set(1)
+	gauge.WithLabelValues("dropped").Set(1)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge)
+
+	options := newOptions(server.URL)
+	options.Interval = 20 * time.Millisecond
+	stop := runExporter(t, options, registry)
+	defer stop()
+
+	requests := r.waitFor(t, 1)
+	if len(requests[0]) != 2 {
+		t.Fatal("Expected both series to be pushed, got", requests[0])
+	}
+	gauge.DeleteLabelValues("dropped")
+	// leave the time for a few more snapshots
+	requests = r.waitFor(t, len(requests)+4)
+
+	stale := 0
+	for _, req := range requests {
+		for _, s := range req {
+			isStale := math.Float64bits(s.Value) == 0x7ff0000000000002
+			dropped := reflect.DeepEqual(s.Labels, []remotewrite.Label{
+				{Name: "__name__", Value: "test_gauge"},
+				{Name: "name", Value: "dropped"},
+			})
+			switch {
+			case dropped && !isStale && stale > 0:
+				t.Error("Expected the dropped series not to be pushed after the stale marker")
+			case !dropped && isStale:
+				t.Error("Expected only the dropped series to be pushed as stale, got", s.Labels)
+			case isStale:
+				stale++
+				if s.Timestamp != req[0].Timestamp {
+					t.Error("Expected the stale marker to have the timestamp of the snapshot")
+				}
+			}
+		}
+	}
+	if stale != 1 {
+		t.Error("Expected the dropped series to be pushed as stale once, got", stale)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	options := remotewrite.DefaultOptions()
+	if _, err := remotewrite.New(options, newGatherer()); err == nil {
+		t.Error("Expected an error with no url")
+	}
+	options.URL = "http://localhost"
+	options.QueueSize = 0
+	if _, err := remotewrite.New(options, newGatherer()); err == nil {
+		t.Error("Expected an error with no queue")
+	}
+}
@@ -0,0 +1,271 @@
+// Package rtnl implements the few route netlink requests the daemon needs
+// to describe the network links, without depending on a netlink library.
+package rtnl
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// the link attributes, from linux/if_link.h
+const (
+	iflaIfname         = 3
+	iflaMtu            = 4
+	iflaMaster         = 10
+	iflaOperstate      = 16
+	iflaLinkinfo       = 18
+	iflaInfoKind       = 1
+	iflaVfinfoList     = 22
+	iflaExtMask        = 29
+	iflaCarrierChanges = 35
+	iflaVfInfo         = 1
+	iflaVfMac          = 1
+	iflaVfStats        = 8
+)
+
+// the link flags, from linux/if.h
+const (
+	iffUp      = 0x1
+	iffLowerUp = 0x10000
+)
+
+// operStates are the names of the operational states
+// of the links, as defined by RFC 2863
+var operStates = []string{"unknown", "notpresent", "down", "lowerlayerdown", "testing", "dormant", "up"}
+
+// operStateUp and operStateUnknown are the operational
+// states relevant to tell whether a link is up
+const (
+	operStateUnknown = 0
+	operStateUp      = 6
+)
+
+// the virtual function statistics, from linux/if_link.h
+const (
+	iflaVfStatsRxPackets = 0
+	iflaVfStatsTxPackets = 1
+	iflaVfStatsRxBytes   = 2
+	iflaVfStatsTxBytes   = 3
+	iflaVfStatsBroadcast = 4
+	iflaVfStatsMulticast = 5
+	iflaVfStatsRxDropped = 7
+	iflaVfStatsTxDropped = 8
+)
+
+// rtextFilterVF asks the kernel to report the virtual functions of the links
+const rtextFilterVF = 1
+
+// sizeofIfInfomsg is the size of the header of the link messages
+const sizeofIfInfomsg = 16
+
+// Link describes a network link as reported by the kernel.
+type Link struct {
+	Index int
+	Name  string
+	// Flags are the IFF_* flags of the link
+	Flags uint32
+	MTU   uint32
+	// OperState is the RFC 2863 operational state of the link
+	OperState uint8
+	// CarrierChanges is the number of times the carrier went up or down
+	CarrierChanges uint32
+	// MasterIndex is the index of the link this one is enslaved to, if any
+	MasterIndex int
+	// Kind is the kind of the virtual links, i.e. bridge or bond, and
+	// empty for the physical ones
+	Kind string
+	// VFs are the statistics of the virtual functions of a physical
+	// function, reported only when explicitly requested
+	VFs []VFStats
+}
+
+// VFStats contains the counters of a virtual function, as reported
+// by the driver of its physical function.
+type VFStats struct {
+	Index int
+	// Reported is false when the driver does not report the counters
+	Reported  bool
+	RxPackets uint64
+	TxPackets uint64
+	RxBytes   uint64
+	TxBytes   uint64
+	Broadcast uint64
+	Multicast uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
+// OperStateName returns the name of the operational state of the link.
+func (l Link) OperStateName() string {
+	if int(l.OperState) < len(operStates) {
+		return operStates[l.OperState]
+	}
+	return operStates[operStateUnknown]
+}
+
+// Up returns true if the link is operationally up. As the virtual links
+// not tracking their state report an unknown state, they are considered up
+// when administratively up and with the lower layer up.
+func (l Link) Up() bool {
+	switch l.OperState {
+	case operStateUp:
+		return true
+	case operStateUnknown:
+		return l.Flags&iffUp != 0 && l.Flags&iffLowerUp != 0
+	}
+	return false
+}
+
+// attr is a netlink attribute.
+type attr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseAttrs splits the given buffer in the netlink attributes it contains.
+// The nested and byte order flags are dropped from the attribute type.
+func parseAttrs(b []byte) ([]attr, error) {
+	var res []attr
+	for len(b) >= 4 {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) & 0x3fff
+		if length < 4 || length > len(b) {
+			return nil, fmt.Errorf("invalid attribute length %d", length)
+		}
+		res = append(res, attr{typ, b[4:length]})
+		// the attributes are aligned to 4 bytes
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return res, nil
+}
+
+// parseLink parses the payload of a RTM_NEWLINK message.
+func parseLink(b []byte) (Link, error) {
+	if len(b) < sizeofIfInfomsg {
+		return Link{}, fmt.Errorf("link message too short: %d bytes", len(b))
+	}
+	res := Link{
+		Index: int(int32(binary.NativeEndian.Uint32(b[4:8]))),
+		Flags: binary.NativeEndian.Uint32(b[8:12]),
+	}
+	attrs, err := parseAttrs(b[sizeofIfInfomsg:])
+	if err != nil {
+		return Link{}, err
+	}
+	for _, a := range attrs {
+		switch a.typ {
+		case iflaIfname:
+			res.Name = cString(a.data)
+		case iflaMtu:
+			if len(a.data) >= 4 {
+				res.MTU = binary.NativeEndian.Uint32(a.data)
+			}
+		case iflaMaster:
+			if len(a.data) >= 4 {
+				res.MasterIndex = int(binary.NativeEndian.Uint32(a.data))
+			}
+		case iflaOperstate:
+			if len(a.data) >= 1 {
+				res.OperState = a.data[0]
+			}
+		case iflaCarrierChanges:
+			if len(a.data) >= 4 {
+				res.CarrierChanges = binary.NativeEndian.Uint32(a.data)
+			}
+		case iflaLinkinfo:
+			info, err := parseAttrs(a.data)
+			if err != nil {
+				return Link{}, err
+			}
+			for _, i := range info {
+				if i.typ == iflaInfoKind {
+					res.Kind = cString(i.data)
+				}
+			}
+		case iflaVfinfoList:
+			vfs, err := parseVFInfoList(a.data)
+			if err != nil {
+				return Link{}, err
+			}
+			res.VFs = vfs
+		}
+	}
+	return res, nil
+}
+
+// parseVFInfoList parses the IFLA_VFINFO_LIST attribute of a link. The index
+// of each virtual function is taken from its IFLA_VF_MAC attribute, always
+// reported, while the statistics are not supported by all the drivers.
+func parseVFInfoList(b []byte) ([]VFStats, error) {
+	infos, err := parseAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+	var res []VFStats
+	for _, info := range infos {
+		if info.typ != iflaVfInfo {
+			continue
+		}
+		attrs, err := parseAttrs(info.data)
+		if err != nil {
+			return nil, err
+		}
+		vf := VFStats{Index: -1}
+		for _, a := range attrs {
+			switch a.typ {
+			case iflaVfMac:
+				if len(a.data) >= 4 {
+					vf.Index = int(binary.NativeEndian.Uint32(a.data))
+				}
+			case iflaVfStats:
+				stats, err := parseAttrs(a.data)
+				if err != nil {
+					return nil, err
+				}
+				vf.Reported = true
+				for _, s := range stats {
+					if len(s.data) < 8 {
+						continue
+					}
+					v := binary.NativeEndian.Uint64(s.data)
+					switch s.typ {
+					case iflaVfStatsRxPackets:
+						vf.RxPackets = v
+					case iflaVfStatsTxPackets:
+						vf.TxPackets = v
+					case iflaVfStatsRxBytes:
+						vf.RxBytes = v
+					case iflaVfStatsTxBytes:
+						vf.TxBytes = v
+					case iflaVfStatsBroadcast:
+						vf.Broadcast = v
+					case iflaVfStatsMulticast:
+						vf.Multicast = v
+					case iflaVfStatsRxDropped:
+						vf.RxDropped = v
+					case iflaVfStatsTxDropped:
+						vf.TxDropped = v
+					}
+				}
+			}
+		}
+		if vf.Index >= 0 {
+			res = append(res, vf)
+		}
+	}
+	return res, nil
+}
+
+// cString returns the given null terminated string.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
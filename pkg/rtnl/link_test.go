@@ -0,0 +1,164 @@
+package rtnl
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// encodeAttr returns the given attribute, padded to 4 bytes.
+func encodeAttr(typ uint16, data []byte) []byte {
+	b := make([]byte, 4, 4+len(data)+3)
+	binary.NativeEndian.PutUint16(b[0:2], uint16(4+len(data)))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func encodeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return b
+}
+
+func encodeUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.NativeEndian.PutUint64(b, v)
+	return b
+}
+
+// encodeVFInfo returns the IFLA_VF_INFO attribute of the virtual function with
+// the given index, with the given IFLA_VF_STATS attributes if any.
+func encodeVFInfo(index uint32, stats ...[]byte) []byte {
+	// struct ifla_vf_mac is the index followed by 32 bytes of address
+	info := encodeAttr(iflaVfMac, append(encodeUint32(index), make([]byte, 32)...))
+	if len(stats) > 0 {
+		var nested []byte
+		for _, s := range stats {
+			nested = append(nested, s...)
+		}
+		info = append(info, encodeAttr(iflaVfStats|0x8000, nested)...)
+	}
+	return encodeAttr(iflaVfInfo|0x8000, info)
+}
+
+// encodeLink returns the payload of a link message with the given attributes.
+func encodeLink(index uint32, attrs ...[]byte) []byte {
+	return encodeLinkWithFlags(index, 0, attrs...)
+}
+
+// encodeLinkWithFlags returns the payload of a link message
+// with the given flags and attributes.
+func encodeLinkWithFlags(index, flags uint32, attrs ...[]byte) []byte {
+	b := make([]byte, sizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(b[4:8], index)
+	binary.NativeEndian.PutUint32(b[8:12], flags)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+var parseLinkTests = []struct {
+	testName string
+	msg      []byte
+	res      Link
+}{
+	{"physical",
+		encodeLink(2, encodeAttr(iflaIfname, []byte("ens1f0\x00"))),
+		Link{Index: 2, Name: "ens1f0"},
+	},
+	{"enslaved",
+		encodeLink(3,
+			encodeAttr(iflaIfname, []byte("ens1f1\x00")),
+			encodeAttr(iflaMaster, encodeUint32(5))),
+		Link{Index: 3, Name: "ens1f1", MasterIndex: 5},
+	},
+	{"bridge",
+		encodeLink(5,
+			encodeAttr(iflaIfname, []byte("br-sec\x00")),
+			// the nested flag is set on the link info
+			encodeAttr(iflaLinkinfo|0x8000, encodeAttr(iflaInfoKind, []byte("bridge\x00")))),
+		Link{Index: 5, Name: "br-sec", Kind: "bridge"},
+	},
+	{"state",
+		encodeLinkWithFlags(4, iffUp|iffLowerUp,
+			encodeAttr(iflaIfname, []byte("net1\x00")),
+			encodeAttr(iflaMtu, encodeUint32(9000)),
+			encodeAttr(iflaOperstate, []byte{operStateUp}),
+			encodeAttr(iflaCarrierChanges, encodeUint32(3))),
+		Link{Index: 4, Name: "net1", Flags: iffUp | iffLowerUp, MTU: 9000, OperState: operStateUp, CarrierChanges: 3},
+	},
+	{"vfs",
+		encodeLink(2,
+			encodeAttr(iflaIfname, []byte("ens1f0\x00")),
+			encodeAttr(iflaVfinfoList|0x8000, append(
+				encodeVFInfo(0,
+					encodeAttr(iflaVfStatsRxPackets, encodeUint64(10)),
+					encodeAttr(iflaVfStatsTxPackets, encodeUint64(20)),
+					encodeAttr(iflaVfStatsRxBytes, encodeUint64(1000)),
+					encodeAttr(iflaVfStatsTxBytes, encodeUint64(2000)),
+					encodeAttr(iflaVfStatsBroadcast, encodeUint64(1)),
+					encodeAttr(iflaVfStatsMulticast, encodeUint64(2)),
+					encodeAttr(iflaVfStatsRxDropped, encodeUint64(3)),
+					encodeAttr(iflaVfStatsTxDropped, encodeUint64(4))),
+				// the driver does not report the counters of this one
+				encodeVFInfo(1)...))),
+		Link{Index: 2, Name: "ens1f0", VFs: []VFStats{
+			{Index: 0, Reported: true, RxPackets: 10, TxPackets: 20, RxBytes: 1000, TxBytes: 2000, Broadcast: 1, Multicast: 2, RxDropped: 3, TxDropped: 4},
+			{Index: 1},
+		}},
+	},
+}
+
+func TestParseLink(t *testing.T) {
+	for _, tst := range parseLinkTests {
+		res, err := parseLink(tst.msg)
+		if err != nil {
+			t.Error(tst.testName, "Unexpected error", err)
+			continue
+		}
+		if !reflect.DeepEqual(res, tst.res) {
+			t.Error(tst.testName, "Different result, expected", tst.res, "got", res)
+		}
+	}
+}
+
+var upTests = []struct {
+	testName  string
+	link      Link
+	up        bool
+	operState string
+}{
+	{"up", Link{OperState: operStateUp}, true, "up"},
+	{"down", Link{OperState: 2, Flags: iffUp}, false, "down"},
+	{"unknownup", Link{Flags: iffUp | iffLowerUp}, true, "unknown"},
+	{"unknownnocarrier", Link{Flags: iffUp}, false, "unknown"},
+	{"invalid", Link{OperState: 42}, false, "unknown"},
+}
+
+func TestUp(t *testing.T) {
+	for _, tst := range upTests {
+		if up := tst.link.Up(); up != tst.up {
+			t.Error(tst.testName, "Expected up", tst.up, "got", up)
+		}
+		if name := tst.link.OperStateName(); name != tst.operState {
+			t.Error(tst.testName, "Expected state", tst.operState, "got", name)
+		}
+	}
+}
+
+func TestParseMalformedLink(t *testing.T) {
+	malformed := [][]byte{
+		make([]byte, sizeofIfInfomsg-1),
+		append(make([]byte, sizeofIfInfomsg), 64, 0, 3, 0, 'e', 't', 'h', '0'),
+	}
+	for _, m := range malformed {
+		if res, err := parseLink(m); err == nil {
+			t.Error("Expected error parsing", m, "got", res)
+		}
+	}
+}
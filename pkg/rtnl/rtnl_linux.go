@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package rtnl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// receiveBufferSize is the initial size of the receive buffer
+const receiveBufferSize = 1 << 16
+
+// ListLinks returns the links of the network namespace of the calling thread.
+func ListLinks() ([]Link, error) {
+	return listLinks(0)
+}
+
+// ListLinksWithVFs returns the links of the network namespace of the calling
+// thread, together with the statistics of the virtual functions of the
+// physical functions.
+func ListLinksWithVFs() ([]Link, error) {
+	return listLinks(rtextFilterVF)
+}
+
+// listLinks returns the links, requesting the extended
+// information set in the given mask.
+func listLinks(extMask uint32) ([]Link, error) {
+	msgs, err := dumpLinks(extMask)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Link, 0, len(msgs))
+	for _, m := range msgs {
+		link, err := parseLink(m)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, link)
+	}
+	return res, nil
+}
+
+// dumpLinks sends a RTM_GETLINK dump request with the given extended
+// information mask, and returns the payload of the link messages received.
+func dumpLinks(extMask uint32) ([][]byte, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to bind netlink socket: %v", err)
+	}
+
+	req := make([]byte, unix.SizeofNlMsghdr+sizeofIfInfomsg)
+	req[unix.SizeofNlMsghdr] = unix.AF_UNSPEC
+	if extMask != 0 {
+		attr := make([]byte, 8)
+		binary.NativeEndian.PutUint16(attr[0:2], 8)
+		binary.NativeEndian.PutUint16(attr[2:4], iflaExtMask)
+		binary.NativeEndian.PutUint32(attr[4:8], extMask)
+		req = append(req, attr...)
+	}
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], unix.RTM_GETLINK)
+	binary.NativeEndian.PutUint16(req[6:8], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to send the link request: %v", err)
+	}
+
+	var res [][]byte
+	buf := make([]byte, receiveBufferSize)
+	for {
+		// the messages of the links with many virtual functions
+		// can exceed the buffer, which is grown as needed
+		n, _, err := unix.Recvfrom(fd, buf, unix.MSG_PEEK|unix.MSG_TRUNC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive the links: %v", err)
+		}
+		if n > len(buf) {
+			buf = make([]byte, n)
+		}
+		n, _, err = unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive the links: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the links: %v", err)
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return res, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, fmt.Errorf("failed to list the links: %v", syscall.Errno(errno))
+					}
+				}
+				return res, nil
+			case unix.RTM_NEWLINK:
+				// the buffer is reused, so the payload is copied
+				res = append(res, append([]byte(nil), m.Data...))
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package rtnl_test
+
+import (
+	"testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/rtnl"
+)
+
+func TestListLinks(t *testing.T) {
+	links, err := rtnl.ListLinks()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, l := range links {
+		if l.Name == "lo" && l.Index > 0 {
+			return
+		}
+	}
+	t.Error("Expected the loopback link to be listed, got", links)
+}
+
+func TestListLinksWithVFs(t *testing.T) {
+	links, err := rtnl.ListLinksWithVFs()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(links) == 0 {
+		t.Error("Expected the links to be listed")
+	}
+}
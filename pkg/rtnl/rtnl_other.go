@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package rtnl
+
+import "fmt"
+
+// ListLinks is supported on linux only.
+func ListLinks() ([]Link, error) {
+	return nil, fmt.Errorf("listing links is not supported on this platform")
+}
+
+// ListLinksWithVFs is supported on linux only.
+func ListLinksWithVFs() ([]Link, error) {
+	return nil, fmt.Errorf("listing links is not supported on this platform")
+}
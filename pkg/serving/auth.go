@@ -0,0 +1,228 @@
+package serving
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// the results of the reviews are cached, so that each scrape does not cost
+// two requests to the API server. The denials are cached for less time, so
+// that a scraper just granted access does not wait for long.
+const (
+	allowedTTL = 2 * time.Minute
+	deniedTTL  = 10 * time.Second
+	// maxCachedReviews bounds the memory used by each cache
+	maxCachedReviews = 1024
+)
+
+// Authorizer authenticates the scrapers with their client certificate or
+// their bearer token, and authorizes them with a SubjectAccessReview on the
+// requested path, the same way kube-rbac-proxy does.
+type Authorizer struct {
+	client        kubernetes.Interface
+	tokenReviews  *reviewCache
+	accessReviews *reviewCache
+}
+
+// tokenReview is the cached result of the review of a token.
+type tokenReview struct {
+	user *authenticationv1.UserInfo
+	err  error
+}
+
+// NewAuthorizer returns an authorizer sending the reviews through the
+// given clientset.
+func NewAuthorizer(client kubernetes.Interface) *Authorizer {
+	return &Authorizer{
+		client:        client,
+		tokenReviews:  newReviewCache(),
+		accessReviews: newReviewCache(),
+	}
+}
+
+// Wrap returns a handler serving the requests with the given handler
+// only if they are authorized.
+func (a *Authorizer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.authenticate(r)
+		if err != nil {
+			klog.V(2).Infof("Unauthenticated request to %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		allowed, err := a.authorize(r, user)
+		if err != nil {
+			klog.Errorf("Failed to authorize request to %s from %s: %v", r.URL.Path, user.Username, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			klog.V(2).Infof("Forbidden request to %s from %s", r.URL.Path, user.Username)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the user sending the request, identified by the
+// verified client certificate if any, or by the bearer token.
+func (a *Authorizer) authenticate(r *http.Request) (*authenticationv1.UserInfo, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		return &authenticationv1.UserInfo{
+			Username: cert.Subject.CommonName,
+			Groups:   cert.Subject.Organization,
+		}, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, fmt.Errorf("no client certificate or bearer token provided")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	if token == "" {
+		return nil, fmt.Errorf("empty bearer token")
+	}
+
+	// the tokens are not kept in memory
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+	if cached, ok := a.tokenReviews.get(key); ok {
+		review := cached.(tokenReview)
+		return review.user, review.err
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	res, err := a.client.AuthenticationV1().TokenReviews().Create(context.Background(), review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review token: %v", err)
+	}
+	if !res.Status.Authenticated {
+		err := fmt.Errorf("token not authenticated: %s", res.Status.Error)
+		a.tokenReviews.add(key, tokenReview{err: err}, deniedTTL)
+		return nil, err
+	}
+	a.tokenReviews.add(key, tokenReview{user: &res.Status.User}, allowedTTL)
+	return &res.Status.User, nil
+}
+
+// authorize returns true if the given user can access the requested path.
+func (a *Authorizer) authorize(r *http.Request, user *authenticationv1.UserInfo) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: requestVerb(r.Method),
+			},
+		},
+	}
+	attributes, err := json.Marshal(review.Spec)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode access review: %v", err)
+	}
+	key := string(attributes)
+	if cached, ok := a.accessReviews.get(key); ok {
+		return cached.(bool), nil
+	}
+
+	res, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), review, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to review access: %v", err)
+	}
+	ttl := allowedTTL
+	if !res.Status.Allowed {
+		ttl = deniedTTL
+	}
+	a.accessReviews.add(key, res.Status.Allowed, ttl)
+	return res.Status.Allowed, nil
+}
+
+// requestVerb returns the verb used to authorize a request with the given method.
+func requestVerb(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "get"
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+// reviewCache holds the results of the reviews until they expire.
+type reviewCache struct {
+	mtx     sync.Mutex
+	entries map[string]cachedReview
+	now     func() time.Time
+}
+
+type cachedReview struct {
+	result  interface{}
+	expires time.Time
+}
+
+func newReviewCache() *reviewCache {
+	return &reviewCache{
+		entries: make(map[string]cachedReview),
+		now:     time.Now,
+	}
+}
+
+// get returns the result cached with the given key, if not expired.
+func (c *reviewCache) get(key string) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// add caches the given result for the given time. The expired entries are
+// dropped when the cache is full, and all of them if none is expired.
+func (c *reviewCache) add(key string, result interface{}, ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := c.now()
+	if len(c.entries) >= maxCachedReviews {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedReviews {
+			c.entries = make(map[string]cachedReview)
+		}
+	}
+	c.entries[key] = cachedReview{result, now.Add(ttl)}
+}
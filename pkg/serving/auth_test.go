@@ -0,0 +1,164 @@
+package serving_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/openshift/network-metrics-daemon/pkg/serving"
+)
+
+// newFakeClient returns a clientset authenticating the "valid" token as the
+// prometheus user, and allowing only the prometheus user to get /metrics.
+func newFakeClient(reviewErr error, reviews *[]authorizationv1.SubjectAccessReviewSpec) *k8sfake.Clientset {
+	client := k8sfake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: "prometheus", Groups: []string{"monitoring"}}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if reviewErr != nil {
+			return true, nil, reviewErr
+		}
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		*reviews = append(*reviews, review.Spec)
+		attributes := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "prometheus" && attributes.Path == "/metrics" && attributes.Verb == "get"
+		return true, review, nil
+	})
+	return client
+}
+
+func TestAuthorizer(t *testing.T) {
+	ca := newCert(t, "ca", nil)
+	clientCert := newCert(t, "prometheus", ca)
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		clientCert     *x509.Certificate
+		reviewErr      error
+		expectedStatus int
+		expectedUser   string
+	}{
+		{"no credentials", "/metrics", "", nil, nil, http.StatusUnauthorized, ""},
+		{"invalid token", "/metrics", "invalid", nil, nil, http.StatusUnauthorized, ""},
+		{"valid token", "/metrics", "valid", nil, nil, http.StatusOK, "prometheus"},
+		{"forbidden path", "/daemon-metrics", "valid", nil, nil, http.StatusForbidden, "prometheus"},
+		{"client certificate", "/metrics", "", clientCert.cert, nil, http.StatusOK, "prometheus"},
+		{"review failure", "/metrics", "valid", nil, errors.New("unavailable"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var reviews []authorizationv1.SubjectAccessReviewSpec
+			authorizer := serving.NewAuthorizer(newFakeClient(tc.reviewErr, &reviews))
+			handler := authorizer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("metrics"))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.clientCert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.clientCert}}}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if tc.expectedStatus == http.StatusOK && rec.Body.String() != "metrics" {
+				t.Errorf("Expected the wrapped handler to serve the request, got %q", rec.Body.String())
+			}
+			if tc.expectedUser == "" {
+				return
+			}
+			if len(reviews) != 1 {
+				t.Fatal("Expected one access review, got", len(reviews))
+			}
+			if reviews[0].User != tc.expectedUser || reviews[0].NonResourceAttributes.Path != tc.path {
+				t.Errorf("Unexpected access review %+v", reviews[0])
+			}
+		})
+	}
+}
+
+func TestAuthorizerCachesReviews(t *testing.T) {
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	client := newFakeClient(nil, &reviews)
+	authorizer := serving.NewAuthorizer(client)
+	handler := authorizer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+
+	serve := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	tokenReviews := func() int {
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetResource().Resource == "tokenreviews" {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := serve("/metrics", "valid"); code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+	if count := tokenReviews(); count != 1 {
+		t.Error("Expected one token review, got", count)
+	}
+	if len(reviews) != 1 {
+		t.Error("Expected one access review, got", len(reviews))
+	}
+
+	// the access to another path is reviewed, not the token
+	for i := 0; i < 2; i++ {
+		if code := serve("/daemon-metrics", "valid"); code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, code)
+		}
+	}
+	if count := tokenReviews(); count != 1 {
+		t.Error("Expected one token review, got", count)
+	}
+	if len(reviews) != 2 {
+		t.Error("Expected two access reviews, got", len(reviews))
+	}
+
+	// the invalid tokens are cached too
+	for i := 0; i < 2; i++ {
+		if code := serve("/metrics", "invalid"); code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, code)
+		}
+	}
+	if count := tokenReviews(); count != 2 {
+		t.Error("Expected two token reviews, got", count)
+	}
+}
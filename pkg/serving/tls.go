@@ -0,0 +1,187 @@
+package serving
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog"
+)
+
+// reloadDelay is how long to wait for the file events to settle
+// before reloading the certificate.
+const reloadDelay = 200 * time.Millisecond
+
+// tlsVersions are the allowed minimum TLS versions, the older
+// ones being insecure
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// TLSOptions controls how TLS is terminated.
+type TLSOptions struct {
+	// CertFile and KeyFile are the serving certificate and key, reloaded when changed
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, if set, enables the verification of the client certificates
+	ClientCAFile string
+	// MinVersion is the minimum TLS version, VersionTLS12 or VersionTLS13
+	MinVersion string
+	// CipherSuites are the IANA names of the allowed cipher suites, among
+	// the secure ones, the go defaults are used when empty
+	CipherSuites []string
+}
+
+// CertReloader serves a certificate and key pair from disk,
+// reloading them when they change.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mtx      sync.RWMutex
+	cert     *tls.Certificate
+}
+
+// NewCertReloader returns a reloader serving the given certificate
+// and key, failing if they cannot be loaded.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s and key %s: %v", r.certFile, r.keyFile, err)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current certificate. It is meant
+// to be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate and the key each time they change. If the
+// new pair is not valid the previous one keeps being served. The directories
+// containing the files are watched, so that the atomic symlink swaps performed
+// when a Secret is updated are detected too. It blocks until stopCh is closed.
+func (r *CertReloader) Watch(stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	for _, dir := range []string{filepath.Dir(r.certFile), filepath.Dir(r.keyFile)} {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch certificate directory %s: %v", dir, err)
+		}
+	}
+
+	// the certificate and the key are usually updated together, so
+	// they are read only once the events settle
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case err := <-watcher.Errors:
+			klog.Warningf("Error watching certificate %s: %v", r.certFile, err)
+		case <-watcher.Events:
+			reload.Reset(reloadDelay)
+		case <-reload.C:
+			if err := r.load(); err != nil {
+				klog.Errorf("Keeping the previous certificate: %v", err)
+				continue
+			}
+			klog.Infof("Reloaded certificate %s", r.certFile)
+		}
+	}
+}
+
+// NewTLSConfig returns the TLS configuration corresponding to the given options,
+// serving the certificate provided by the given reloader.
+func NewTLSConfig(options TLSOptions, reloader *CertReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS version %s, must be VersionTLS12 or VersionTLS13", options.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if len(options.CipherSuites) > 0 {
+		suites, err := cipherSuites(options.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = suites
+	}
+
+	if options.ClientCAFile != "" {
+		data, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA %s: %v", options.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", options.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		// scrapers not presenting a certificate can still use a bearer token
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// cipherSuites returns the ids of the cipher suites with the given IANA names.
+// The suites with known security issues are rejected.
+func cipherSuites(names []string) ([]uint16, error) {
+	ids := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	res := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if insecure[name] {
+			return nil, fmt.Errorf("insecure cipher suite %s", name)
+		}
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid cipher suite %s", name)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
@@ -0,0 +1,234 @@
+package serving_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/network-metrics-daemon/pkg/serving"
+)
+
+type certPair struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCert generates a certificate with the given common name, signed by the
+// given parent, or self signed as a CA if the parent is nil.
+func newCert(t *testing.T, commonName string, parent *certPair) *certPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal("Failed to generate serial", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"group1"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal("Failed to create certificate", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Failed to parse certificate", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key", err)
+	}
+	return &certPair{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal("Failed to write", path, err)
+	}
+}
+
+func writePair(t *testing.T, dir string, pair *certPair) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, certFile, pair.certPEM)
+	writeFile(t, keyFile, pair.keyPEM)
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	certFile, keyFile := writePair(t, dir, newCert(t, "server", ca))
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.certPEM)
+	invalidCAFile := filepath.Join(dir, "invalid.crt")
+	writeFile(t, invalidCAFile, []byte("invalid"))
+
+	reloader, err := serving.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate", err)
+	}
+
+	tests := []struct {
+		name        string
+		options     serving.TLSOptions
+		expectError bool
+		check       func(t *testing.T, c *tls.Config)
+	}{
+		{
+			"defaults",
+			serving.TLSOptions{},
+			false,
+			func(t *testing.T, c *tls.Config) {
+				if c.MinVersion != tls.VersionTLS12 || c.CipherSuites != nil || c.ClientAuth != tls.NoClientCert {
+					t.Errorf("Unexpected config %+v", c)
+				}
+			},
+		},
+		{
+			"version and ciphers",
+			serving.TLSOptions{
+				MinVersion:   "VersionTLS13",
+				CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+			},
+			false,
+			func(t *testing.T, c *tls.Config) {
+				expected := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+				if c.MinVersion != tls.VersionTLS13 || len(c.CipherSuites) != 2 ||
+					c.CipherSuites[0] != expected[0] || c.CipherSuites[1] != expected[1] {
+					t.Errorf("Unexpected config %+v", c)
+				}
+			},
+		},
+		{
+			"client ca",
+			serving.TLSOptions{ClientCAFile: caFile},
+			false,
+			func(t *testing.T, c *tls.Config) {
+				if c.ClientAuth != tls.VerifyClientCertIfGiven || c.ClientCAs == nil {
+					t.Errorf("Unexpected config %+v", c)
+				}
+			},
+		},
+		{"invalid version", serving.TLSOptions{MinVersion: "TLS12"}, true, nil},
+		{"insecure version", serving.TLSOptions{MinVersion: "VersionTLS10"}, true, nil},
+		{"invalid cipher", serving.TLSOptions{CipherSuites: []string{"TLS_INVALID"}}, true, nil},
+		{"insecure cipher", serving.TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_AES_128_CBC_SHA256"}}, true, nil},
+		{"missing client ca", serving.TLSOptions{ClientCAFile: filepath.Join(dir, "missing.crt")}, true, nil},
+		{"invalid client ca", serving.TLSOptions{ClientCAFile: invalidCAFile}, true, nil},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := serving.NewTLSConfig(tc.options, reloader)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+			tc.check(t, c)
+		})
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	certFile, keyFile := writePair(t, dir, newCert(t, "first", ca))
+
+	if _, err := serving.NewCertReloader(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Error("Expected an error loading a missing certificate")
+	}
+
+	reloader, err := serving.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate", err)
+	}
+	config, err := serving.NewTLSConfig(serving.TLSOptions{}, reloader)
+	if err != nil {
+		t.Fatal("Failed to build TLS config", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal("Failed to listen", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go reloader.Watch(stopCh)
+	// give the watcher the time to start
+	time.Sleep(100 * time.Millisecond)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	servedName := func() string {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: pool})
+		if err != nil {
+			t.Fatal("Failed to connect", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	if name := servedName(); name != "first" {
+		t.Fatal("Expected the first certificate to be served, got", name)
+	}
+
+	// an invalid pair is ignored
+	writeFile(t, keyFile, []byte("invalid"))
+	time.Sleep(500 * time.Millisecond)
+	if name := servedName(); name != "first" {
+		t.Fatal("Expected the first certificate to be kept, got", name)
+	}
+
+	writePair(t, dir, newCert(t, "second", ca))
+	for i := 0; i < 50; i++ {
+		if servedName() == "second" {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("Expected the second certificate to be served")
+}